@@ -0,0 +1,213 @@
+// Package diagnostics describes the errors and warnings found while
+// vulcanizing, along with where they were found.
+package diagnostics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/tbuckley/vulcanize/htmlutils"
+)
+
+type Severity string
+
+const (
+	SEVERITY_ERROR   Severity = "error"
+	SEVERITY_WARNING Severity = "warning"
+)
+
+// Codes identify the kind of problem a diagnostic reports. Passes added to
+// the pipeline use their name as the code.
+const (
+	CODE_CONFIG          = "config"
+	CODE_MISSING_FILE    = "missing-file"
+	CODE_IMPORT_CYCLE    = "import-cycle"
+	CODE_UNNAMED_ELEMENT = "unnamed-element"
+	CODE_MINIFY          = "minify"
+	CODE_INTEGRITY       = "integrity"
+	CODE_INTERNAL        = "internal"
+)
+
+// Diagnostic is a problem found in a file. Errors are returned as
+// *Diagnostic values, so they can be recovered with errors.As.
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	Message  string   `json:"message"`
+
+	// File, Line and Col locate the offending element, when known. Lines and
+	// columns start at 1.
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+	Col  int    `json:"col,omitempty"`
+
+	// Chain lists the files imported on the way to File, starting with the
+	// input and ending with File
+	Chain []string `json:"chain,omitempty"`
+
+	// Err is the underlying error, if any
+	Err error `json:"-"`
+}
+
+// Errorf creates an error diagnostic with the given code
+func Errorf(code string, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{Severity: SEVERITY_ERROR, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Warningf creates a warning diagnostic with the given code
+func Warningf(code string, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{Severity: SEVERITY_WARNING, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// FromError returns the diagnostic wrapped by err, or creates one describing
+// err
+func FromError(err error) *Diagnostic {
+	var d *Diagnostic
+	if errors.As(err, &d) {
+		return d
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return ReadError(pathErr.Path, err)
+	}
+	return Errorf(CODE_INTERNAL, "%v", err).Wrap(err)
+}
+
+// ReadError describes a failure to read filename
+func ReadError(filename string, err error) *Diagnostic {
+	cause := err
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		cause = pathErr.Err
+	}
+	return Errorf(CODE_MISSING_FILE, "Could not read %s: %v", filename, cause).Wrap(err)
+}
+
+// At locates the diagnostic at pos, unless pos is nil
+func (d *Diagnostic) At(pos *htmlutils.Position) *Diagnostic {
+	if pos != nil {
+		d.File, d.Line, d.Col = pos.File, pos.Line, pos.Col
+	}
+	return d
+}
+
+// InFile locates the diagnostic in file when no position is known
+func (d *Diagnostic) InFile(file string) *Diagnostic {
+	if d.File == "" {
+		d.File = file
+	}
+	return d
+}
+
+// Wrap records err as the cause of the diagnostic
+func (d *Diagnostic) Wrap(err error) *Diagnostic {
+	d.Err = err
+	return d
+}
+
+// Location returns file:line:col, leaving out the parts that are unknown
+func (d *Diagnostic) Location() string {
+	switch {
+	case d.File == "":
+		return ""
+	case d.Line == 0:
+		return d.File
+	case d.Col == 0:
+		return fmt.Sprintf("%s:%d", d.File, d.Line)
+	}
+	return fmt.Sprintf("%s:%d:%d", d.File, d.Line, d.Col)
+}
+
+func (d *Diagnostic) Error() string {
+	if location := d.Location(); location != "" {
+		return location + ": " + d.Message
+	}
+	return d.Message
+}
+
+func (d *Diagnostic) Unwrap() error {
+	return d.Err
+}
+
+// List is an error holding several diagnostics, returned when processing
+// continues past the first error
+type List []*Diagnostic
+
+func (l List) Error() string {
+	messages := make([]string, 0, len(l))
+	for _, d := range l {
+		messages = append(messages, d.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Err returns l as an error, or nil if it is empty
+func (l List) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// All returns the diagnostics held by err, which is either a List or a
+// single error
+func All(err error) []*Diagnostic {
+	if err == nil {
+		return nil
+	}
+	var l List
+	if errors.As(err, &l) {
+		return l
+	}
+	return []*Diagnostic{FromError(err)}
+}
+
+// WriteText writes one diagnostic per line, followed by the import chain that
+// led to it
+func WriteText(w io.Writer, diags []*Diagnostic) error {
+	for _, d := range diags {
+		prefix := ""
+		if location := d.Location(); location != "" {
+			prefix = location + ": "
+		}
+		_, err := fmt.Fprintf(w, "%s%s: %s [%s]\n", prefix, d.Severity, d.Message, d.Code)
+		if err != nil {
+			return err
+		}
+		if len(d.Chain) > 1 {
+			_, err = fmt.Fprintf(w, "    imported by %s\n", strings.Join(d.Chain[:len(d.Chain)-1], " -> "))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes diags as a JSON array
+func WriteJSON(w io.Writer, diags []*Diagnostic) error {
+	if diags == nil {
+		diags = []*Diagnostic{}
+	}
+	data, err := json.MarshalIndent(diags, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Write writes diags in the named format, either "text" or "json"
+func Write(w io.Writer, format string, diags []*Diagnostic) error {
+	switch format {
+	case "text":
+		return WriteText(w, diags)
+	case "json":
+		return WriteJSON(w, diags)
+	}
+	return fmt.Errorf("Unknown diagnostics format %q", format)
+}
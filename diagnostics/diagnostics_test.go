@@ -0,0 +1,74 @@
+package diagnostics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"github.com/tbuckley/vulcanize/htmlutils"
+)
+
+func TestFromError(t *testing.T) {
+	d := Errorf(CODE_IMPORT_CYCLE, "a.html imports itself")
+	if FromError(fmt.Errorf("import: %w", d)) != d {
+		t.Error("Expected the wrapped diagnostic to be returned")
+	}
+
+	err := &fs.PathError{Op: "open", Path: "a.html", Err: fs.ErrNotExist}
+	d = FromError(err)
+	if d.Code != CODE_MISSING_FILE {
+		t.Errorf("Expected code %v, got %v", CODE_MISSING_FILE, d.Code)
+	}
+	if !errors.Is(d, fs.ErrNotExist) {
+		t.Errorf("Expected %v to wrap %v", d, fs.ErrNotExist)
+	}
+
+	d = FromError(errors.New("boom"))
+	if d.Code != CODE_INTERNAL || d.Error() != "boom" {
+		t.Errorf("Expected internal error boom, got %v", d)
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	d := ReadError("b.css", &fs.PathError{Op: "open", Path: "b.css", Err: fs.ErrNotExist})
+	d.At(&htmlutils.Position{File: "a.html", Line: 3, Col: 5})
+	d.Chain = []string{"index.html", "a.html"}
+	warning := Warningf(CODE_UNNAMED_ELEMENT, "No name").InFile("c.html")
+
+	buf := new(bytes.Buffer)
+	if err := WriteText(buf, []*Diagnostic{d, warning}); err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := "a.html:3:5: error: Could not read b.css: file does not exist [missing-file]\n" +
+		"    imported by index.html\n" +
+		"c.html: warning: No name [unnamed-element]\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %v, got %v", expected, buf.String())
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	d := Errorf(CODE_CONFIG, "Bad config").InFile("config.json")
+	buf := new(bytes.Buffer)
+	if err := Write(buf, "json", []*Diagnostic{d}); err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := `[
+  {
+    "severity": "error",
+    "code": "config",
+    "message": "Bad config",
+    "file": "config.json"
+  }
+]
+`
+	if buf.String() != expected {
+		t.Errorf("Expected %v, got %v", expected, buf.String())
+	}
+
+	if err := Write(buf, "xml", nil); err == nil {
+		t.Error("Expected an unknown format to fail")
+	}
+}
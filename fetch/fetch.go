@@ -0,0 +1,195 @@
+// Package fetch retrieves remote imports, scripts and stylesheets, caching
+// them on disk.
+package fetch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var (
+	REMOTE_URL = regexp.MustCompile("^[hH][tT][tT][pP][sS]?://")
+	// FETCH_TIMEOUT bounds each request made by an HTTPFetcher without a
+	// Client of its own
+	FETCH_TIMEOUT = 30 * time.Second
+	// MAX_REDIRECTS is the number of redirects followed for one request
+	MAX_REDIRECTS = 10
+)
+
+// IsRemote returns true if ref is an absolute http(s) URL
+func IsRemote(ref string) bool {
+	return REMOTE_URL.MatchString(ref)
+}
+
+// Fetcher retrieves the content at an absolute URL
+type Fetcher interface {
+	Fetch(url string) ([]byte, error)
+}
+
+// HTTPFetcher fetches URLs with net/http
+type HTTPFetcher struct {
+	// Client is used for requests. When nil, a client timing out after
+	// FETCH_TIMEOUT is used.
+	Client *http.Client
+	// Allows, when set, vets every URL a request is redirected to, so that
+	// an allowed host cannot send the fetch elsewhere
+	Allows func(url string) bool
+}
+
+func (f *HTTPFetcher) Fetch(u string) ([]byte, error) {
+	client := f.Client
+	if client == nil {
+		client = &http.Client{Timeout: FETCH_TIMEOUT}
+	}
+	if f.Allows != nil {
+		checked := *client
+		checked.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if !f.Allows(req.URL.String()) {
+				return fmt.Errorf("Fetching %s: redirected to %s, host not allowed", u, req.URL)
+			}
+			if f.Client != nil && f.Client.CheckRedirect != nil {
+				return f.Client.CheckRedirect(req, via)
+			}
+			if len(via) >= MAX_REDIRECTS {
+				return fmt.Errorf("Fetching %s: stopped after %d redirects", u, MAX_REDIRECTS)
+			}
+			return nil
+		}
+		client = &checked
+	}
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("Fetching %s: %s", u, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Remote fetches URLs from an allow-list of hosts, caching the responses in
+// memory and optionally on disk
+type Remote struct {
+	// Fetcher retrieves URLs that are not cached
+	Fetcher Fetcher
+	// CacheDir, when set, stores responses across runs
+	CacheDir string
+	// Hosts lists the hosts that may be fetched from. When empty, any host
+	// is allowed.
+	Hosts []string
+	// Offline serves only from the cache
+	Offline bool
+
+	mu   sync.Mutex
+	memo map[string][]byte
+}
+
+// NewRemote creates a Remote that fetches with net/http, following redirects
+// only to allowed hosts
+func NewRemote() *Remote {
+	r := new(Remote)
+	r.Fetcher = &HTTPFetcher{Allows: r.Allows}
+	return r
+}
+
+// Allows returns true if u is a remote URL on an allowed host
+func (r *Remote) Allows(u string) bool {
+	if !IsRemote(u) {
+		return false
+	}
+	if len(r.Hosts) == 0 {
+		return true
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	for _, host := range r.Hosts {
+		if parsed.Host == host || parsed.Hostname() == host {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch returns the content at u, from the cache if possible
+func (r *Remote) Fetch(u string) ([]byte, error) {
+	if !r.Allows(u) {
+		return nil, fmt.Errorf("Fetching %s: host not allowed", u)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if data, ok := r.memo[u]; ok {
+		return data, nil
+	}
+
+	data, err := r.readCache(u)
+	if err != nil {
+		if r.Offline {
+			return nil, fmt.Errorf("Fetching %s: not cached and offline", u)
+		}
+		if r.Fetcher == nil {
+			return nil, fmt.Errorf("Fetching %s: no fetcher", u)
+		}
+		data, err = r.Fetcher.Fetch(u)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.writeCache(u, data); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.memo == nil {
+		r.memo = make(map[string][]byte)
+	}
+	r.memo[u] = data
+	return data, nil
+}
+
+// cacheFile returns the name of the cache file holding u
+func (r *Remote) cacheFile(u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return filepath.Join(r.CacheDir, hex.EncodeToString(sum[:]))
+}
+
+func (r *Remote) readCache(u string) ([]byte, error) {
+	if r.CacheDir == "" {
+		return nil, fs.ErrNotExist
+	}
+	return ioutil.ReadFile(r.cacheFile(u))
+}
+
+func (r *Remote) writeCache(u string, data []byte) error {
+	if r.CacheDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(r.CacheDir, 0775); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.cacheFile(u), data, 0664)
+}
+
+// ReadFile reads name from remote if it is an allowed URL, and from fsys
+// otherwise. remote may be nil.
+func ReadFile(fsys fs.FS, remote *Remote, name string) ([]byte, error) {
+	if IsRemote(name) {
+		if remote == nil {
+			return nil, fmt.Errorf("Fetching %s: remote files are disabled", name)
+		}
+		return remote.Fetch(name)
+	}
+	return fs.ReadFile(fsys, name)
+}
@@ -0,0 +1,118 @@
+package fetch
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testServer(hits *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*hits++
+		if r.URL.Path == "/missing.html" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "content of %s", r.URL.Path)
+	}))
+}
+
+func TestRemote_Fetch(t *testing.T) {
+	hits := 0
+	server := testServer(&hits)
+	defer server.Close()
+
+	r := &Remote{Fetcher: &HTTPFetcher{Client: server.Client()}, CacheDir: t.TempDir()}
+	for n := 0; n < 2; n++ {
+		data, err := r.Fetch(server.URL + "/a.html")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if string(data) != "content of /a.html" {
+			t.Errorf("Expected fetched content, got %q", data)
+		}
+	}
+	if hits != 1 {
+		t.Errorf("Expected 1 request, got %v", hits)
+	}
+
+	if _, err := r.Fetch(server.URL + "/missing.html"); err == nil {
+		t.Error("Expected a 404 to fail")
+	}
+
+	// A new Remote sharing the cache directory should not need the server
+	offline := &Remote{CacheDir: r.CacheDir, Offline: true}
+	data, err := offline.Fetch(server.URL + "/a.html")
+	if err != nil || string(data) != "content of /a.html" {
+		t.Errorf("Expected cached content, got %q (%v)", data, err)
+	}
+	if _, err := offline.Fetch(server.URL + "/b.html"); err == nil {
+		t.Error("Expected an uncached URL to fail offline")
+	}
+	if hits != 2 {
+		t.Errorf("Expected offline fetches not to hit the server, got %v requests", hits)
+	}
+}
+
+func TestRemote_Allows(t *testing.T) {
+	r := &Remote{Hosts: []string{"cdn.example.com", "localhost:8080"}}
+
+	allowed := []string{"https://cdn.example.com/a.html", "http://localhost:8080/b.js"}
+	for _, u := range allowed {
+		if !r.Allows(u) {
+			t.Errorf("Expected %v to be allowed", u)
+		}
+	}
+
+	denied := []string{"https://evil.example.com/a.html", "http://localhost/b.js", "a.html", "data:text/css,"}
+	for _, u := range denied {
+		if r.Allows(u) {
+			t.Errorf("Expected %v to be denied", u)
+		}
+	}
+	if _, err := r.Fetch("https://evil.example.com/a.html"); err == nil {
+		t.Error("Expected fetching from a denied host to fail")
+	}
+}
+
+func TestHTTPFetcher_Redirect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/away":
+			http.Redirect(w, r, "http://evil.example.com/x.js", http.StatusFound)
+		case "/here":
+			http.Redirect(w, r, "/x.js", http.StatusFound)
+		default:
+			fmt.Fprint(w, "x")
+		}
+	}))
+	defer server.Close()
+
+	r := &Remote{Hosts: []string{strings.TrimPrefix(server.URL, "http://")}}
+	r.Fetcher = &HTTPFetcher{Client: server.Client(), Allows: r.Allows}
+	if data, err := r.Fetch(server.URL + "/here"); err != nil || string(data) != "x" {
+		t.Errorf("Expected a redirect on the same host to be followed, got %q (%v)", data, err)
+	}
+	if _, err := r.Fetch(server.URL + "/away"); err == nil || !strings.Contains(err.Error(), "host not allowed") {
+		t.Errorf("Expected a redirect to another host to fail, got %v", err)
+	}
+}
+
+func TestHTTPFetcher_Timeout(t *testing.T) {
+	done := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	timeout := FETCH_TIMEOUT
+	FETCH_TIMEOUT = 50 * time.Millisecond
+	defer func() { FETCH_TIMEOUT = timeout }()
+	if _, err := new(HTTPFetcher).Fetch(server.URL + "/hung.js"); err == nil {
+		t.Error("Expected a hung request to time out")
+	}
+}
@@ -0,0 +1,143 @@
+// Package graph records the dependencies found while vulcanizing a document
+// and exports them for visualization.
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Kinds of node in the graph
+const (
+	KIND_IMPORT     = "import"
+	KIND_STYLESHEET = "stylesheet"
+	KIND_SCRIPT     = "script"
+)
+
+// Node is a file referenced by the document
+type Node struct {
+	ID       string `json:"id"`
+	Kind     string `json:"kind"`
+	Size     int64  `json:"size"`
+	Excluded bool   `json:"excluded"`
+}
+
+// Edge is a reference from one file to another
+type Edge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Element is the markup of the referencing element
+	Element string `json:"element"`
+}
+
+// Graph is the set of files reachable from a document
+type Graph struct {
+	Nodes []*Node `json:"nodes"`
+	Edges []*Edge `json:"edges"`
+
+	index map[string]*Node
+}
+
+// New creates an empty graph
+func New() *Graph {
+	return &Graph{
+		Nodes: make([]*Node, 0),
+		Edges: make([]*Edge, 0),
+		index: make(map[string]*Node),
+	}
+}
+
+// AddNode adds a node to the graph, returning the existing node if one with
+// the same id was already added
+func (g *Graph) AddNode(id, kind string, size int64, excluded bool) *Node {
+	if n, ok := g.index[id]; ok {
+		return n
+	}
+	n := &Node{ID: id, Kind: kind, Size: size, Excluded: excluded}
+	g.Nodes = append(g.Nodes, n)
+	g.index[id] = n
+	return n
+}
+
+// Node returns the node with the given id, or nil
+func (g *Graph) Node(id string) *Node {
+	return g.index[id]
+}
+
+// AddEdge records that from references to through element
+func (g *Graph) AddEdge(from, to, element string) {
+	g.Edges = append(g.Edges, &Edge{From: from, To: to, Element: element})
+}
+
+// Rename replaces the id of every node, and the ends of every edge, with
+// rename(id)
+func (g *Graph) Rename(rename func(id string) string) {
+	g.index = make(map[string]*Node)
+	for _, n := range g.Nodes {
+		n.ID = rename(n.ID)
+		g.index[n.ID] = n
+	}
+	for _, e := range g.Edges {
+		e.From, e.To = rename(e.From), rename(e.To)
+	}
+}
+
+// WriteJSON writes the graph as a JSON object with nodes and edges
+func (g *Graph) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// WriteDOT writes the graph in Graphviz DOT format
+func (g *Graph) WriteDOT(w io.Writer) error {
+	lines := []string{"digraph vulcanize {"}
+	for _, n := range g.Nodes {
+		label := "\"" + escape(path.Base(n.ID)) + "\\n" + escape(fmt.Sprintf("%s, %d bytes", n.Kind, n.Size)) + "\""
+		attrs := fmt.Sprintf("label=%s shape=%s", label, shapes[n.Kind])
+		if n.Excluded {
+			attrs += " style=dashed"
+		}
+		lines = append(lines, fmt.Sprintf("  %s [%s];", quote(n.ID), attrs))
+	}
+	for _, e := range g.Edges {
+		lines = append(lines, fmt.Sprintf("  %s -> %s [tooltip=%s];", quote(e.From), quote(e.To), quote(e.Element)))
+	}
+	lines = append(lines, "}", "")
+	_, err := io.WriteString(w, strings.Join(lines, "\n"))
+	return err
+}
+
+// Write writes the graph in the format implied by filename: JSON for .json
+// files and DOT otherwise
+func (g *Graph) Write(w io.Writer, filename string) error {
+	if strings.ToLower(path.Ext(filename)) == ".json" {
+		return g.WriteJSON(w)
+	}
+	return g.WriteDOT(w)
+}
+
+var shapes = map[string]string{
+	KIND_IMPORT:     "box",
+	KIND_STYLESHEET: "note",
+	KIND_SCRIPT:     "ellipse",
+}
+
+// quote returns s as a DOT string literal
+func quote(s string) string {
+	return "\"" + escape(s) + "\""
+}
+
+// escape escapes backslashes, quotes and newlines in s for use in a DOT
+// string literal
+func escape(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	return strings.Replace(s, "\n", "\\n", -1)
+}
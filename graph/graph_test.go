@@ -0,0 +1,88 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func testGraph() *Graph {
+	g := New()
+	g.AddNode("index.html", KIND_IMPORT, 120, false)
+	g.AddNode("a.html", KIND_IMPORT, 80, false)
+	g.AddNode("http://cdn/x.js", KIND_SCRIPT, 0, true)
+	g.AddEdge("index.html", "a.html", `<link rel="import" href="a.html">`)
+	g.AddEdge("a.html", "http://cdn/x.js", `<script src="http://cdn/x.js">`)
+	return g
+}
+
+func TestGraph_AddNode(t *testing.T) {
+	g := testGraph()
+	n := g.AddNode("a.html", KIND_IMPORT, 0, false)
+	if n.Size != 80 || len(g.Nodes) != 3 {
+		t.Error("adding an existing node should return the original")
+	}
+}
+
+func TestGraph_Rename(t *testing.T) {
+	g := testGraph()
+	g.Rename(func(id string) string { return "app/" + id })
+	if g.Node("app/a.html") == nil || g.Node("a.html") != nil {
+		t.Error("Expected nodes to be found by their new id")
+	}
+	if g.Edges[0].From != "app/index.html" || g.Edges[0].To != "app/a.html" {
+		t.Errorf("Expected renamed edge, got %v", g.Edges[0])
+	}
+}
+
+func TestGraph_WriteDOT(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := testGraph().Write(buf, "deps.dot"); err != nil {
+		t.Fatal(err.Error())
+	}
+	dot := buf.String()
+
+	expected := []string{
+		"digraph vulcanize {",
+		`"index.html" [label="index.html\nimport, 120 bytes" shape=box];`,
+		`"http://cdn/x.js" [label="x.js\nscript, 0 bytes" shape=ellipse style=dashed];`,
+		`"index.html" -> "a.html" [tooltip="<link rel=\"import\" href=\"a.html\">"];`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(dot, line) {
+			t.Errorf("Expected %v in %v", line, dot)
+		}
+	}
+}
+
+func TestGraph_WriteDOTEscapes(t *testing.T) {
+	g := New()
+	g.AddNode("dir\\a\"b\n.html", KIND_IMPORT, 1, false)
+	buf := new(bytes.Buffer)
+	if err := g.WriteDOT(buf); err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := `"dir\\a\"b\n.html" [label="dir\\a\"b\n.html\nimport, 1 bytes" shape=box];`
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("Expected %v in %v", expected, buf.String())
+	}
+}
+
+func TestGraph_WriteJSON(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := testGraph().Write(buf, "deps.json"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var g Graph
+	if err := json.Unmarshal(buf.Bytes(), &g); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(g.Nodes) != 3 || len(g.Edges) != 2 {
+		t.Errorf("Expected 3 nodes and 2 edges, got %v", buf.String())
+	}
+	if !g.Nodes[2].Excluded || g.Edges[1].Element != `<script src="http://cdn/x.js">` {
+		t.Errorf("Node or edge details lost: %v", buf.String())
+	}
+}
@@ -0,0 +1,142 @@
+package htmlutils
+
+import (
+	"code.google.com/p/go.net/html"
+	"strings"
+)
+
+var (
+	// PRESERVED_ELEMENTS hold content whose whitespace is never changed
+	PRESERVED_ELEMENTS = map[string]bool{
+		"pre": true, "listing": true, "textarea": true, "script": true,
+		"style": true, "template": true, "xmp": true, "plaintext": true,
+	}
+	// BLOCK_ELEMENTS are laid out on their own line, so whitespace next to
+	// them is not rendered
+	BLOCK_ELEMENTS = map[string]bool{
+		"html": true, "head": true, "body": true, "title": true,
+		"address": true, "article": true, "aside": true, "blockquote": true,
+		"dd": true, "details": true, "dialog": true, "div": true, "dl": true,
+		"dt": true, "fieldset": true, "figcaption": true, "figure": true,
+		"footer": true, "form": true, "h1": true, "h2": true, "h3": true,
+		"h4": true, "h5": true, "h6": true, "header": true, "hgroup": true,
+		"hr": true, "li": true, "main": true, "nav": true, "ol": true, "p": true,
+		"pre": true, "section": true, "summary": true, "table": true,
+		"caption": true, "colgroup": true, "thead": true, "tbody": true,
+		"tfoot": true, "tr": true, "td": true, "th": true, "ul": true,
+		"polymer-element": true,
+	}
+	// METADATA_ELEMENTS are not rendered, so whitespace between them and
+	// block elements is not either
+	METADATA_ELEMENTS = map[string]bool{
+		"base": true, "link": true, "meta": true, "script": true,
+		"style": true, "template": true,
+	}
+
+	spaceReplacer = strings.NewReplacer("\t", " ", "\n", " ", "\f", " ", "\r", " ")
+)
+
+// Compact collapses runs of whitespace in text to a single space and removes
+// whitespace that is not rendered, such as the indentation between block
+// elements. The content of PRESERVED_ELEMENTS is left as is.
+func (f *Fragment) Compact() {
+	f.compact(f.FirstNode, nil)
+}
+
+// compact compacts the siblings starting at first, whose parent is parent.
+// Siblings that are all block or metadata elements drop any whitespace
+// between them.
+func (f *Fragment) compact(first *html.Node, parent *html.Node) {
+	blocks := isBlockList(first, true)
+	for n := first; n != nil; {
+		next := n.NextSibling
+		switch n.Type {
+		case html.ElementNode:
+			if !PRESERVED_ELEMENTS[n.Data] {
+				f.compact(n.FirstChild, n)
+			}
+		case html.TextNode:
+			text := collapseSpace(n.Data)
+			if blocks || isBoundary(n.PrevSibling, parent) {
+				text = strings.TrimLeft(text, " ")
+			}
+			if isBoundary(next, parent) {
+				text = strings.TrimRight(text, " ")
+			}
+			if text == "" {
+				RemoveNode(f, n)
+			} else {
+				n.Data = text
+			}
+		}
+		n = next
+	}
+}
+
+// Indent puts each block element on its own line, indented by indent for each
+// level of nesting. Elements holding text or inline elements stay on one line.
+// The fragment should be compacted first.
+func (f *Fragment) Indent(indent string) {
+	if !isBlockList(f.FirstNode, false) {
+		return
+	}
+	for n := f.FirstNode; n != nil; n = n.NextSibling {
+		if n != f.FirstNode {
+			InsertBefore(f, n, &html.Node{Type: html.TextNode, Data: "\n"})
+		}
+		f.indent(n, indent, 0)
+	}
+}
+
+// indent indents the children of n, which is nested depth levels deep
+func (f *Fragment) indent(n *html.Node, indent string, depth int) {
+	if n.Type != html.ElementNode || PRESERVED_ELEMENTS[n.Data] || n.FirstChild == nil || !isBlockList(n.FirstChild, false) {
+		return
+	}
+	prefix := "\n" + strings.Repeat(indent, depth+1)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		n.InsertBefore(&html.Node{Type: html.TextNode, Data: prefix}, c)
+		f.indent(c, indent, depth+1)
+	}
+	n.AppendChild(&html.Node{Type: html.TextNode, Data: "\n" + strings.Repeat(indent, depth)})
+}
+
+// isBlockList returns true if the siblings starting at first are all block
+// or metadata elements, comments, or whitespace if allowSpace is set
+func isBlockList(first *html.Node, allowSpace bool) bool {
+	for n := first; n != nil; n = n.NextSibling {
+		switch n.Type {
+		case html.CommentNode, html.DoctypeNode:
+		case html.ElementNode:
+			if n.Namespace != "" || !BLOCK_ELEMENTS[n.Data] && !METADATA_ELEMENTS[n.Data] {
+				return false
+			}
+		case html.TextNode:
+			if !allowSpace || collapseSpace(n.Data) != " " && n.Data != "" {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isBoundary returns true if whitespace next to sibling, a neighbour of a text
+// node in parent, is not rendered. A nil sibling is the edge of parent.
+func isBoundary(sibling *html.Node, parent *html.Node) bool {
+	if sibling == nil {
+		return parent == nil || parent.Namespace == "" && BLOCK_ELEMENTS[parent.Data]
+	}
+	return sibling.Type == html.DoctypeNode ||
+		sibling.Type == html.ElementNode && sibling.Namespace == "" && BLOCK_ELEMENTS[sibling.Data]
+}
+
+// collapseSpace replaces each run of whitespace in text with a single space
+func collapseSpace(text string) string {
+	text = spaceReplacer.Replace(text)
+	for strings.Contains(text, "  ") {
+		text = strings.Replace(text, "  ", " ", -1)
+	}
+	return text
+}
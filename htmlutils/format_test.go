@@ -0,0 +1,81 @@
+package htmlutils
+
+import (
+	"strings"
+	"testing"
+)
+
+const formatDoc = `<html><head>
+  <title> A   page </title>
+  <script>
+    if (a  <  b) {}
+  </script>
+</head>
+<body>
+  <div>
+    <p>Some  <b>bold</b>
+      text</p>
+    <pre>  keep
+   this</pre>
+  </div>
+  <template>
+    <span> as is </span>
+  </template>
+</body></html>`
+
+func TestFragment_Compact(t *testing.T) {
+	doc, err := Parse(strings.NewReader(formatDoc), "test.html", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	doc.Compact()
+	expected := "<html><head><title>A page</title><script>\n    if (a  <  b) {}\n  </script></head>" +
+		"<body><div><p>Some <b>bold</b> text</p><pre>  keep\n   this</pre></div>" +
+		"<template>\n    <span> as is </span>\n  </template></body></html>"
+	if doc.String() != expected {
+		t.Errorf("Expected %v, got %v", expected, doc.String())
+	}
+}
+
+func TestFragment_Indent(t *testing.T) {
+	doc, err := Parse(strings.NewReader(formatDoc), "test.html", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	doc.Compact()
+	doc.Indent("  ")
+	expected := `<html>
+  <head>
+    <title>A page</title>
+    <script>
+    if (a  <  b) {}
+  </script>
+  </head>
+  <body>
+    <div>
+      <p>Some <b>bold</b> text</p>
+      <pre>  keep
+   this</pre>
+    </div>
+    <template>
+    <span> as is </span>
+  </template>
+  </body>
+</html>`
+	if doc.String() != expected {
+		t.Errorf("Expected %v, got %v", expected, doc.String())
+	}
+}
+
+func TestFragment_CompactInline(t *testing.T) {
+	source := "<span>a</span>\n  <span>b</span> <script></script> c"
+	doc, err := Parse(strings.NewReader(source), "test.html", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	doc.Compact()
+	expected := "<html><head></head><body><span>a</span> <span>b</span> <script></script> c</body></html>"
+	if doc.String() != expected {
+		t.Errorf("Expected %v, got %v", expected, doc.String())
+	}
+}
@@ -3,11 +3,18 @@ package htmlutils
 import (
 	"bytes"
 	"code.google.com/p/go.net/html"
-	"os"
+	"code.google.com/p/go.net/html/atom"
+	"io"
+	"io/fs"
 )
 
 type Fragment struct {
 	FirstNode, LastNode *html.Node
+	// Sources records where the nodes in the fragment were parsed from
+	Sources map[*html.Node]*Source
+	// Doctype is the source text of the doctype of a full document, if it
+	// had one
+	Doctype string
 }
 
 func FromNode(n *html.Node) *Fragment {
@@ -17,18 +24,46 @@ func FromNode(n *html.Node) *Fragment {
 	}
 }
 
-// FromFile loads an Fragment from a file
-func FromFile(filename string, parent *html.Node) (*Fragment, error) {
-	f, err := os.Open(filename)
+// FromFile loads a Fragment from a file in fsys. The nodes are attached to
+// parent, or parsed as a full document if parent is nil.
+func FromFile(fsys fs.FS, filename string, parent *html.Node) (*Fragment, error) {
+	f, err := fsys.Open(filename)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
+	return Parse(f, filename, parent)
+}
 
-	ns, err := html.ParseFragment(f, parent)
+// Parse reads a Fragment from r, recording the position of each element in
+// filename. The nodes are attached to parent, or parsed as a full document if
+// parent is nil.
+func Parse(r io.Reader, filename string, parent *html.Node) (*Fragment, error) {
+	data, err := readAll(r)
 	if err != nil {
 		return nil, err
 	}
 
+	// Imports are parsed as if they were the content of a <template>, since
+	// the element the <link> sat in (eg. <head>) would otherwise drop any
+	// content not allowed there
+	var context *html.Node
+	if parent != nil {
+		context = &html.Node{
+			Type:     html.ElementNode,
+			Data:     "template",
+			DataAtom: atom.Template,
+		}
+	}
+
+	ns, err := html.ParseFragment(bytes.NewReader(data), context)
+	if err != nil {
+		return nil, err
+	}
+	if len(ns) == 0 {
+		return new(Fragment), nil
+	}
+
 	// Set the parent
 	for _, n := range ns {
 		n.Parent = parent
@@ -44,10 +79,49 @@ func FromFile(filename string, parent *html.Node) (*Fragment, error) {
 		}
 	}
 
-	return &Fragment{
+	f := &Fragment{
 		FirstNode: ns[0],
 		LastNode:  ns[len(ns)-1],
-	}, nil
+	}
+	f.attachSources(filename, data)
+	if parent != nil {
+		f.Doctype = ""
+	}
+	return f, nil
+}
+
+// Clone returns a deep copy of the fragment with its nodes attached to parent
+func (f *Fragment) Clone(parent *html.Node) *Fragment {
+	clone := &Fragment{Doctype: f.Doctype}
+	var prev *html.Node
+	f.eachNode(func(n *html.Node) {
+		c := CloneNode(n)
+		clone.copySources(f, n, c)
+		c.Parent = parent
+		c.PrevSibling = prev
+		if prev != nil {
+			prev.NextSibling = c
+		} else {
+			clone.FirstNode = c
+		}
+		prev = c
+	})
+	clone.LastNode = prev
+	return clone
+}
+
+// copySources records the sources of orig and its descendants in other for
+// the corresponding nodes of the clone c
+func (f *Fragment) copySources(other *Fragment, orig *html.Node, c *html.Node) {
+	if len(other.Sources) == 0 {
+		return
+	}
+	if src := other.Sources[orig]; src != nil {
+		f.SetSource(c, src)
+	}
+	for o, n := orig.FirstChild, c.FirstChild; o != nil && n != nil; o, n = o.NextSibling, n.NextSibling {
+		f.copySources(other, o, n)
+	}
 }
 
 func (f *Fragment) Search(pred HTMLPred) []*html.Node {
@@ -59,14 +133,13 @@ func (f *Fragment) Search(pred HTMLPred) []*html.Node {
 	return matches
 }
 
+// String renders the fragment, keeping the source text of unchanged nodes
 func (f *Fragment) String() string {
-	contents := ""
+	buf := new(bytes.Buffer)
 	f.eachNode(func(n *html.Node) {
-		buf := new(bytes.Buffer)
-		html.Render(buf, n)
-		contents += buf.String()
+		f.render(buf, n)
 	})
-	return contents
+	return buf.String()
 }
 
 func (f *Fragment) eachNode(fn NodeFn) {
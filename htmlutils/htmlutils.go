@@ -9,7 +9,7 @@ import (
 // exists for the given node.
 func Attr(n *html.Node, attrKey string) (val string, ok bool) {
 	for _, attr := range n.Attr {
-		if attr.Key == attrKey {
+		if attr.Key == attrKey || attrName(attr) == attrKey {
 			return attr.Val, true
 		}
 	}
@@ -19,7 +19,7 @@ func Attr(n *html.Node, attrKey string) (val string, ok bool) {
 // SetAttr sets the value of the attribute for the given node
 func SetAttr(n *html.Node, attrKey string, attrValue string) {
 	for i, attr := range n.Attr {
-		if attr.Key == attrKey {
+		if attr.Key == attrKey || attrName(attr) == attrKey {
 			attr.Val = attrValue
 			n.Attr[i] = attr
 			return
@@ -31,10 +31,19 @@ func SetAttr(n *html.Node, attrKey string, attrValue string) {
 	})
 }
 
+// attrName returns the name of an attribute, prefixed by its namespace as in
+// xlink:href
+func attrName(attr html.Attribute) string {
+	if attr.Namespace != "" {
+		return attr.Namespace + ":" + attr.Key
+	}
+	return attr.Key
+}
+
 // TextContent returns the text within the given node
 func TextContent(n *html.Node) string {
 	child := n.FirstChild
-	if child.Type == html.TextNode {
+	if child != nil && child.Type == html.TextNode {
 		return child.Data
 	}
 	return ""
@@ -43,11 +52,22 @@ func TextContent(n *html.Node) string {
 // SetTextContent sets the text within the given node
 func SetTextContent(n *html.Node, text string) {
 	child := n.FirstChild
-	if child.Type == html.TextNode {
+	if child == nil {
+		n.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+	} else if child.Type == html.TextNode {
 		child.Data = text
 	}
 }
 
+// StartTag returns the markup of the start tag for the given element
+func StartTag(n *html.Node) string {
+	tag := "<" + n.Data
+	for _, attr := range n.Attr {
+		tag += " " + attrName(attr) + "=\"" + html.EscapeString(attr.Val) + "\""
+	}
+	return tag + ">"
+}
+
 // GetElementByID returns the element with the given id, if one exists
 func GetElementByID(doc *html.Node, id string) *html.Node {
 	matches := Search(doc, func(n *html.Node) bool {
@@ -60,6 +80,7 @@ func GetElementByID(doc *html.Node, id string) *html.Node {
 	return nil
 }
 
+// RemoveNode detaches n from its parent and siblings
 func RemoveNode(doc *Fragment, n *html.Node) {
 	if n.PrevSibling != nil {
 		n.PrevSibling.NextSibling = n.NextSibling
@@ -67,12 +88,39 @@ func RemoveNode(doc *Fragment, n *html.Node) {
 	if n.NextSibling != nil {
 		n.NextSibling.PrevSibling = n.PrevSibling
 	}
+	if n.Parent != nil {
+		if n.Parent.FirstChild == n {
+			n.Parent.FirstChild = n.NextSibling
+		}
+		if n.Parent.LastChild == n {
+			n.Parent.LastChild = n.PrevSibling
+		}
+	}
 	if doc.FirstNode == n {
 		doc.FirstNode = n.NextSibling
 	}
 	if doc.LastNode == n {
 		doc.LastNode = n.PrevSibling
 	}
+	n.Parent, n.PrevSibling, n.NextSibling = nil, nil, nil
+}
+
+// InsertBefore inserts n immediately before ref, which may be a top-level node
+// of doc
+func InsertBefore(doc *Fragment, ref *html.Node, n *html.Node) {
+	if ref.Parent != nil {
+		ref.Parent.InsertBefore(n, ref)
+	} else {
+		n.PrevSibling = ref.PrevSibling
+		n.NextSibling = ref
+		if ref.PrevSibling != nil {
+			ref.PrevSibling.NextSibling = n
+		}
+		ref.PrevSibling = n
+	}
+	if doc.FirstNode == ref {
+		doc.FirstNode = n
+	}
 }
 
 func ReplaceNodeWithNode(doc *Fragment, origNode *html.Node, newNode *html.Node) {
@@ -89,6 +137,7 @@ func ReplaceNodeWithFragment(doc *Fragment, node *html.Node, fragment *Fragment)
 	fragment.eachNode(func(n *html.Node) {
 		n.Parent = node.Parent
 	})
+	doc.adoptSources(fragment)
 
 	// Insert into linked list
 	fragment.FirstNode.PrevSibling = node.PrevSibling
@@ -119,6 +168,22 @@ func ReplaceNodeWithFragment(doc *Fragment, node *html.Node, fragment *Fragment)
 	}
 }
 
+// CloneNode returns a deep copy of n that is not attached to any tree
+func CloneNode(n *html.Node) *html.Node {
+	clone := &html.Node{
+		Type:      n.Type,
+		DataAtom:  n.DataAtom,
+		Data:      n.Data,
+		Namespace: n.Namespace,
+		Attr:      make([]html.Attribute, len(n.Attr)),
+	}
+	copy(clone.Attr, n.Attr)
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		clone.AppendChild(CloneNode(child))
+	}
+	return clone
+}
+
 func CreateScript(content string) *html.Node {
 	script := &html.Node{
 		Type:     html.ElementNode,
@@ -133,6 +198,19 @@ func CreateScript(content string) *html.Node {
 	return script
 }
 
+func CreateImport(href string) *html.Node {
+	link := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "link",
+		DataAtom: atom.Link,
+		Attr: []html.Attribute{
+			html.Attribute{Key: "rel", Val: "import"},
+			html.Attribute{Key: "href", Val: href},
+		},
+	}
+	return link
+}
+
 func CreateExternalScript(src string) *html.Node {
 	script := &html.Node{
 		Type:     html.ElementNode,
@@ -158,3 +236,29 @@ func CreateStyle(content string) *html.Node {
 	style.AppendChild(textnode)
 	return style
 }
+
+func CreateMeta(httpEquiv string, content string) *html.Node {
+	meta := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "meta",
+		DataAtom: atom.Meta,
+		Attr: []html.Attribute{
+			html.Attribute{Key: "http-equiv", Val: httpEquiv},
+			html.Attribute{Key: "content", Val: content},
+		},
+	}
+	return meta
+}
+
+func CreateStylesheet(href string) *html.Node {
+	link := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "link",
+		DataAtom: atom.Link,
+		Attr: []html.Attribute{
+			html.Attribute{Key: "rel", Val: "stylesheet"},
+			html.Attribute{Key: "href", Val: href},
+		},
+	}
+	return link
+}
@@ -0,0 +1,128 @@
+package htmlutils
+
+import (
+	"bytes"
+	"code.google.com/p/go.net/html"
+	"io"
+	"strings"
+)
+
+var (
+	// VOID_ELEMENTS never have content or an end tag
+	VOID_ELEMENTS = map[string]bool{
+		"area": true, "base": true, "br": true, "col": true, "command": true,
+		"embed": true, "hr": true, "img": true, "input": true, "keygen": true,
+		"link": true, "meta": true, "param": true, "source": true, "track": true,
+		"wbr": true,
+	}
+	// LITERAL_ELEMENTS hold text that is written without escaping
+	LITERAL_ELEMENTS = map[string]bool{
+		"iframe": true, "noembed": true, "noframes": true, "noscript": true,
+		"plaintext": true, "script": true, "style": true, "xmp": true,
+	}
+
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#13;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", `"`, "&quot;", "\r", "&#13;")
+)
+
+// Render writes n and its descendants to w. Nodes that are unchanged since
+// they were parsed are written as they appeared in the source, keeping their
+// entities, attribute quoting and void-tag style. Other nodes are written
+// with bare boolean attributes, and SVG and MathML elements keep the case of
+// their names and attributes (viewBox, clipPath).
+func (f *Fragment) Render(w io.Writer, n *html.Node) error {
+	buf := new(bytes.Buffer)
+	f.render(buf, n)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (f *Fragment) render(buf *bytes.Buffer, n *html.Node) {
+	switch n.Type {
+	case html.DocumentNode:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f.render(buf, c)
+		}
+	case html.TextNode:
+		buf.WriteString(f.text(n))
+	case html.CommentNode:
+		buf.WriteString("<!--" + n.Data + "-->")
+	case html.DoctypeNode:
+		buf.WriteString("<!DOCTYPE " + n.Data + ">")
+	case html.ElementNode:
+		f.renderElement(buf, n)
+	}
+}
+
+func (f *Fragment) renderElement(buf *bytes.Buffer, n *html.Node) {
+	tag, selfClosing := f.startTag(n)
+	buf.WriteString(tag)
+	if VOID_ELEMENTS[n.Data] || selfClosing && n.FirstChild == nil {
+		return
+	}
+
+	// A newline at the start of these elements is dropped by the parser
+	if c := n.FirstChild; c != nil && c.Type == html.TextNode && strings.HasPrefix(c.Data, "\n") {
+		switch n.Data {
+		case "pre", "listing", "textarea":
+			buf.WriteByte('\n')
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		f.render(buf, c)
+	}
+	buf.WriteString("</" + n.Data + ">")
+}
+
+// startTag returns the start tag of n, and whether it is self-closing
+func (f *Fragment) startTag(n *html.Node) (string, bool) {
+	src := f.Source(n)
+	if src != nil && src.Raw != "" && tagSignature(n.Data, n.Attr) == tagSignature(n.Data, src.Attr) {
+		return src.Raw, src.SelfClosing
+	}
+
+	tag := "<" + n.Data
+	for _, attr := range n.Attr {
+		tag += " " + f.attr(src, attr)
+	}
+	// SVG and MathML elements without content are closed by their start tag
+	// unless they were written with an end tag
+	if n.Namespace != "" && n.FirstChild == nil && (src == nil || src.Raw == "" || src.SelfClosing) {
+		return tag + "/>", true
+	}
+	if !VOID_ELEMENTS[n.Data] {
+		return tag + ">", false
+	}
+	// Void elements keep the style they were written in, defaulting to />
+	if src != nil && src.Raw != "" && !src.SelfClosing {
+		return tag + ">", false
+	}
+	return tag + "/>", true
+}
+
+// attr returns the source of an attribute, as written if it is unchanged
+func (f *Fragment) attr(src *Source, attr html.Attribute) string {
+	key := attrName(attr)
+	if src != nil && src.RawAttr != nil {
+		for i, orig := range src.Attr {
+			if strings.EqualFold(orig.Key, key) && orig.Val == attr.Val {
+				return src.RawAttr[i]
+			}
+		}
+	}
+	if attr.Val == "" {
+		return key
+	}
+	return key + `="` + attrEscaper.Replace(attr.Val) + `"`
+}
+
+// text returns the source of a text node, as written if it is unchanged
+func (f *Fragment) text(n *html.Node) string {
+	if p := n.Parent; p != nil && p.Type == html.ElementNode && p.Namespace == "" && LITERAL_ELEMENTS[p.Data] {
+		return n.Data
+	}
+	if src := f.Source(n); src != nil && src.Raw != "" && src.Data == n.Data {
+		return src.Raw
+	}
+	return textEscaper.Replace(n.Data)
+}
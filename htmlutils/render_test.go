@@ -0,0 +1,95 @@
+package htmlutils
+
+import (
+	"code.google.com/p/go.net/html"
+	"strings"
+	"testing"
+)
+
+func TestFragment_String(t *testing.T) {
+	source := `<div hidden class='a'>Tom &amp; Jerry&apos;s &nbsp;<br><img src=a.png alt="x"/></div>`
+	doc, err := Parse(strings.NewReader(source), "test.html", &html.Node{Type: html.ElementNode, Data: "body"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if doc.String() != source {
+		t.Errorf("Expected %v, got %v", source, doc.String())
+	}
+
+	// Changed nodes are rebuilt, keeping the source of unchanged attributes
+	img := doc.Search(HasTagnameP("img"))[0]
+	SetAttr(img, "src", "b.png")
+	div := doc.Search(HasTagnameP("div"))[0]
+	div.Attr = append(div.Attr, html.Attribute{Key: "data-new"})
+	div.AppendChild(&html.Node{Type: html.TextNode, Data: "<&>"})
+	div.AppendChild(CreateImport("x.html"))
+	expected := `<div hidden class='a' data-new>Tom &amp; Jerry&apos;s &nbsp;<br><img src="b.png" alt="x"/>&lt;&amp;&gt;<link rel="import" href="x.html"/></div>`
+	if doc.String() != expected {
+		t.Errorf("Expected %v, got %v", expected, doc.String())
+	}
+}
+
+func TestFragment_StringAttrOrder(t *testing.T) {
+	// The parser sorts the attributes of formatting elements, which must
+	// still match their source
+	source := `<p><a id="target" href="x" class=y>a</a> <b id=q class=r>b</b></p>`
+	doc, err := Parse(strings.NewReader(source), "test.html", &html.Node{Type: html.ElementNode, Data: "body"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if doc.String() != source {
+		t.Errorf("Expected %v, got %v", source, doc.String())
+	}
+
+	a := doc.Search(HasTagnameP("a"))[0]
+	SetAttr(a, "href", "z")
+	expected := `<p><a id="target" href="z" class=y>a</a> <b id=q class=r>b</b></p>`
+	if doc.String() != expected {
+		t.Errorf("Expected %v, got %v", expected, doc.String())
+	}
+}
+
+func TestFragment_StringDocument(t *testing.T) {
+	source := "<!DOCTYPE html>\n<html><head><title>A &amp; B</title></head><body><svg><circle r=\"1\"/></svg><a data-x=1/></a>\n<script>if (a < b && c) {}</script></body></html>"
+	doc, err := Parse(strings.NewReader(source), "index.html", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if doc.Doctype != "<!DOCTYPE html>" {
+		t.Errorf("Expected doctype <!DOCTYPE html>, got %v", doc.Doctype)
+	}
+	expected := source[len("<!DOCTYPE html>\n"):]
+	if doc.String() != expected {
+		t.Errorf("Expected %v, got %v", expected, doc.String())
+	}
+}
+
+func TestFragment_StringForeign(t *testing.T) {
+	source := `<svg viewBox="0 0 10 10"><clipPath id="c"><rect width="1"></rect></clipPath><image xlink:href="a.png"/></svg>`
+	doc, err := Parse(strings.NewReader(source), "test.html", &html.Node{Type: html.ElementNode, Data: "body"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if doc.String() != source {
+		t.Errorf("Expected %v, got %v", source, doc.String())
+	}
+
+	// Rebuilt foreign elements keep their case and how they were closed
+	for _, n := range doc.Search(IsElementP) {
+		n.Attr = append(n.Attr, html.Attribute{Key: "class", Val: "x"})
+	}
+	SetAttr(doc.Search(HasTagnameP("image"))[0], "xlink:href", "b.png")
+	expected := `<svg viewBox="0 0 10 10" class="x"><clipPath id="c" class="x"><rect width="1" class="x"></rect></clipPath><image xlink:href="b.png" class="x"/></svg>`
+	if doc.String() != expected {
+		t.Errorf("Expected %v, got %v", expected, doc.String())
+	}
+}
+
+func TestSplitAttrs(t *testing.T) {
+	raw := `<a href = "x y" title='z' hidden data-x=1/>`
+	expected := []string{`href = "x y"`, `title='z'`, `hidden`, `data-x=1/`}
+	attrs := splitAttrs(raw)
+	if strings.Join(attrs, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected %v, got %v", expected, attrs)
+	}
+}
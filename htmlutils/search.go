@@ -69,6 +69,20 @@ func HasAttrValueP(attrKey, attrValue string) HTMLPred {
 	}
 }
 
+// AttrMatchesP creates a predicate that checks whether a node has the
+// attribute with a value accepted by match
+func AttrMatchesP(attrKey string, match func(string) bool) HTMLPred {
+	return func(n *html.Node) bool {
+		val, ok := Attr(n, attrKey)
+		return ok && match(val)
+	}
+}
+
+// IsElementP checks whether a node is an element
+func IsElementP(n *html.Node) bool {
+	return n.Type == html.ElementNode
+}
+
 // HasTagnameP creates a predicate that checks whether a node has the tagname
 func HasTagnameP(tagname string) HTMLPred {
 	return func(n *html.Node) bool {
@@ -100,6 +114,22 @@ func OrP(preds ...HTMLPred) HTMLPred {
 	}
 }
 
+// ParentP creates a predicate that checks whether a node's parent meets the
+// predicate
+func ParentP(pred HTMLPred) HTMLPred {
+	return func(n *html.Node) bool {
+		return n.Parent != nil && pred(n.Parent)
+	}
+}
+
+// AncestorP creates a predicate that checks whether any of a node's ancestors
+// meets the predicate
+func AncestorP(pred HTMLPred) HTMLPred {
+	return func(n *html.Node) bool {
+		return Closest(n, pred) != nil
+	}
+}
+
 // NotP creates a predicate that inverts the given predicate
 func NotP(pred HTMLPred) HTMLPred {
 	return func(n *html.Node) bool {
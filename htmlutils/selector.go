@@ -0,0 +1,316 @@
+package htmlutils
+
+import (
+	"code.google.com/p/go.net/html"
+	"fmt"
+	"strings"
+)
+
+// Compile turns a CSS selector into a predicate. It supports type and
+// universal selectors, #id, .class, attribute selectors ([attr], =, ~=, ^=,
+// $=, *=), :not(), the descendant and child combinators, and selector lists.
+func Compile(selector string) (HTMLPred, error) {
+	p := &selectorParser{src: selector}
+	pred, err := p.parseList()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.done() {
+		return nil, p.errorf("unexpected %q", p.peek())
+	}
+	return pred, nil
+}
+
+// MustCompile is like Compile but panics if the selector is invalid
+func MustCompile(selector string) HTMLPred {
+	pred, err := Compile(selector)
+	if err != nil {
+		panic(err)
+	}
+	return pred
+}
+
+// Query returns every node in the fragment matching the CSS selector
+func (f *Fragment) Query(selector string) ([]*html.Node, error) {
+	pred, err := Compile(selector)
+	if err != nil {
+		return nil, err
+	}
+	return f.Search(pred), nil
+}
+
+// selectorParser is a recursive-descent parser for CSS selectors
+type selectorParser struct {
+	src string
+	pos int
+}
+
+func (p *selectorParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("Invalid selector %q at %d: %s", p.src, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *selectorParser) done() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *selectorParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// skipSpace skips whitespace and returns true if there was any
+func (p *selectorParser) skipSpace() bool {
+	start := p.pos
+	for !p.done() && strings.IndexByte(" \t\n\r\f", p.peek()) >= 0 {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+// parseList parses selectors separated by commas
+func (p *selectorParser) parseList() (HTMLPred, error) {
+	preds := make([]HTMLPred, 0, 1)
+	for {
+		p.skipSpace()
+		pred, err := p.parseComplex()
+		if err != nil {
+			return nil, err
+		}
+		preds = append(preds, pred)
+		p.skipSpace()
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+	if len(preds) == 1 {
+		return preds[0], nil
+	}
+	return OrP(preds...), nil
+}
+
+// parseComplex parses compound selectors joined by combinators. The result
+// matches the last compound, with the earlier ones checked against its
+// ancestors.
+func (p *selectorParser) parseComplex() (HTMLPred, error) {
+	pred, err := p.parseCompound()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		space := p.skipSpace()
+		switch {
+		case p.peek() == '>':
+			p.pos++
+			p.skipSpace()
+			right, err := p.parseCompound()
+			if err != nil {
+				return nil, err
+			}
+			pred = AndP(right, ParentP(pred))
+		case space && !p.done() && p.peek() != ',' && p.peek() != ')':
+			right, err := p.parseCompound()
+			if err != nil {
+				return nil, err
+			}
+			pred = AndP(right, AncestorP(pred))
+		default:
+			return pred, nil
+		}
+	}
+}
+
+// parseCompound parses a type selector followed by any number of id, class,
+// attribute and :not() selectors
+func (p *selectorParser) parseCompound() (HTMLPred, error) {
+	preds := []HTMLPred{IsElementP}
+	start := p.pos
+	if p.peek() == '*' {
+		p.pos++
+	} else if name := p.ident(false); name != "" {
+		preds = append(preds, hasTagnameFoldP(name))
+	}
+
+	for !p.done() {
+		switch p.peek() {
+		case '#':
+			p.pos++
+			id := p.ident(false)
+			if id == "" {
+				return nil, p.errorf("expected an id")
+			}
+			preds = append(preds, HasAttrValueP("id", id))
+		case '.':
+			p.pos++
+			class := p.ident(false)
+			if class == "" {
+				return nil, p.errorf("expected a class name")
+			}
+			preds = append(preds, AttrMatchesP("class", containsWord(class)))
+		case '[':
+			pred, err := p.parseAttr()
+			if err != nil {
+				return nil, err
+			}
+			preds = append(preds, pred)
+		case ':':
+			if !strings.HasPrefix(p.src[p.pos:], ":not(") {
+				return nil, p.errorf("only :not() is supported")
+			}
+			p.pos += len(":not(")
+			pred, err := p.parseList()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if p.peek() != ')' {
+				return nil, p.errorf("expected )")
+			}
+			p.pos++
+			preds = append(preds, NotP(pred))
+		default:
+			return p.compound(preds, start)
+		}
+	}
+	return p.compound(preds, start)
+}
+
+// compound joins the predicates of a compound selector that started at start
+func (p *selectorParser) compound(preds []HTMLPred, start int) (HTMLPred, error) {
+	if p.pos == start {
+		if p.done() {
+			return nil, p.errorf("expected a selector")
+		}
+		return nil, p.errorf("unexpected %q", p.peek())
+	}
+	return AndP(preds...), nil
+}
+
+// parseAttr parses an attribute selector such as [type="text/javascript"]
+func (p *selectorParser) parseAttr() (HTMLPred, error) {
+	p.pos++
+	p.skipSpace()
+	key := p.ident(true)
+	if key == "" {
+		return nil, p.errorf("expected an attribute name")
+	}
+	p.skipSpace()
+	if p.peek() == ']' {
+		p.pos++
+		return attrFoldP(key, func(string) bool { return true }), nil
+	}
+
+	op := ""
+	for _, candidate := range []string{"=", "~=", "^=", "$=", "*="} {
+		if strings.HasPrefix(p.src[p.pos:], candidate) {
+			op = candidate
+		}
+	}
+	if op == "" {
+		return nil, p.errorf("expected ], =, ~=, ^=, $= or *=")
+	}
+	p.pos += len(op)
+	p.skipSpace()
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.peek() != ']' {
+		return nil, p.errorf("expected ]")
+	}
+	p.pos++
+
+	switch op {
+	case "~=":
+		return attrFoldP(key, containsWord(value)), nil
+	case "^=":
+		return attrFoldP(key, func(val string) bool {
+			return value != "" && strings.HasPrefix(val, value)
+		}), nil
+	case "$=":
+		return attrFoldP(key, func(val string) bool {
+			return value != "" && strings.HasSuffix(val, value)
+		}), nil
+	case "*=":
+		return attrFoldP(key, func(val string) bool {
+			return value != "" && strings.Contains(val, value)
+		}), nil
+	}
+	return attrFoldP(key, func(val string) bool {
+		return val == value
+	}), nil
+}
+
+// ident reads a name made of letters, digits, - and _. Attribute names may
+// also hold a namespace prefix, as in xlink:href.
+func (p *selectorParser) ident(attr bool) string {
+	start := p.pos
+	for !p.done() {
+		c := p.peek()
+		if c == '-' || c == '_' || c >= 0x80 ||
+			'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+			attr && c == ':' {
+			p.pos++
+		} else {
+			break
+		}
+	}
+	return p.src[start:p.pos]
+}
+
+// value reads an attribute value, either quoted or a bare name
+func (p *selectorParser) value() (string, error) {
+	quote := p.peek()
+	if quote != '"' && quote != '\'' {
+		value := p.ident(false)
+		if value == "" {
+			return "", p.errorf("expected a value")
+		}
+		return value, nil
+	}
+	end := strings.IndexByte(p.src[p.pos+1:], quote)
+	if end < 0 {
+		return "", p.errorf("unterminated string")
+	}
+	value := p.src[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	return value, nil
+}
+
+// hasTagnameFoldP matches tag names regardless of case, so that the camel-cased
+// names of SVG elements such as clipPath can be selected
+func hasTagnameFoldP(tagname string) HTMLPred {
+	return func(n *html.Node) bool {
+		return n.Type == html.ElementNode && strings.EqualFold(n.Data, tagname)
+	}
+}
+
+// attrFoldP matches elements with an attribute named attrKey regardless of
+// case, such as viewBox, whose value is accepted by match
+func attrFoldP(attrKey string, match func(string) bool) HTMLPred {
+	return func(n *html.Node) bool {
+		for _, attr := range n.Attr {
+			if strings.EqualFold(attrName(attr), attrKey) {
+				return match(attr.Val)
+			}
+		}
+		return false
+	}
+}
+
+// containsWord returns a function checking for word in a whitespace-separated
+// list
+func containsWord(word string) func(string) bool {
+	return func(val string) bool {
+		for _, field := range strings.Fields(val) {
+			if field == word {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,104 @@
+package htmlutils
+
+import (
+	"code.google.com/p/go.net/html"
+	"strings"
+	"testing"
+)
+
+const selectorDoc = `<div id="main" class="app wide">
+  <script>inline</script>
+  <script type="text/javascript">typed</script>
+  <script src="a.js"></script>
+  <section><p class="note">deep</p></section>
+  <p data-href="http://example.com/x.html">child</p>
+</div>
+<p lang="en-US">outside</p>`
+
+func TestCompile(t *testing.T) {
+	doc, err := Parse(strings.NewReader(selectorDoc), "test.html", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	cases := map[string]string{
+		"p":                          "deep child outside",
+		"*[class]":                   "div:app wide deep",
+		"div p":                      "deep child",
+		"div > p":                    "child",
+		"div > section > p, p[lang]": "deep outside",
+		"#main > script:not([src])":  "inline typed",
+		"script:not([type]):not([src]), script[type=\"text/javascript\"]:not([src])": "inline typed",
+		".note":                 "deep",
+		"div.wide.app":          "div:app wide",
+		"[class~=wide]":         "div:app wide",
+		"[data-href^='http:']":  "child",
+		"[data-href$='.html']":  "child",
+		"[data-href*=example]":  "child",
+		"p[lang=en]":            "",
+		"P":                     "deep child outside",
+		"section :not(section)": "deep",
+		"div:not(.app) p":       "",
+	}
+	for selector, expected := range cases {
+		nodes, err := doc.Query(selector)
+		if err != nil {
+			t.Errorf("Could not compile %q: %v", selector, err)
+			continue
+		}
+		found := make([]string, 0, len(nodes))
+		for _, n := range nodes {
+			found = append(found, describe(n))
+		}
+		if strings.Join(found, " ") != expected {
+			t.Errorf("Expected %q to match %q, got %q", selector, expected, strings.Join(found, " "))
+		}
+	}
+}
+
+func TestCompile_foreign(t *testing.T) {
+	source := `<svg viewBox="0 0 10 10"><clipPath id="c"><rect/></clipPath><use xlink:href="#c"/></svg>`
+	doc, err := Parse(strings.NewReader(source), "test.html", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	cases := map[string]string{
+		"clipPath":          "clipPath",
+		"clippath > rect":   "rect",
+		"[viewBox]":         "svg",
+		"[xlink:href='#c']": "use",
+	}
+	for selector, expected := range cases {
+		nodes, err := doc.Query(selector)
+		if err != nil {
+			t.Errorf("Could not compile %q: %v", selector, err)
+			continue
+		}
+		found := make([]string, 0, len(nodes))
+		for _, n := range nodes {
+			found = append(found, n.Data)
+		}
+		if strings.Join(found, " ") != expected {
+			t.Errorf("Expected %q to match %q, got %q", selector, expected, strings.Join(found, " "))
+		}
+	}
+}
+
+func TestCompile_invalid(t *testing.T) {
+	for _, selector := range []string{"", "p >", "[href", "[href!=x]", "p:first-child", "p,", ":not(p", "[href='x]", "p $"} {
+		if _, err := Compile(selector); err == nil {
+			t.Errorf("Expected %q to be invalid", selector)
+		}
+	}
+}
+
+// describe returns the text of n, or its tag and class for elements with
+// several children
+func describe(n *html.Node) string {
+	if n.FirstChild != nil && n.FirstChild == n.LastChild {
+		return TextContent(n)
+	}
+	class, _ := Attr(n, "class")
+	return n.Data + ":" + class
+}
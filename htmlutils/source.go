@@ -0,0 +1,259 @@
+package htmlutils
+
+import (
+	"bytes"
+	"code.google.com/p/go.net/html"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Position is a location in a source file. Lines and columns start at 1.
+type Position struct {
+	File string
+	Line int
+	Col  int
+}
+
+// Source records where a node was parsed from, and how it was written
+type Source struct {
+	// Start is the position of the start tag
+	Start Position
+	// Content is the position just after the start tag, where the text of
+	// elements like <script> begins
+	Content Position
+
+	// Raw is the source text of an element's start tag, or of a text node
+	Raw string
+	// SelfClosing is true if the start tag ended with />
+	SelfClosing bool
+	// Attr holds an element's attributes as they were parsed, and RawAttr
+	// the source text of each one. They tell whether Raw still describes
+	// the element.
+	Attr    []html.Attribute
+	RawAttr []string
+	// Data is the decoded text of a text node
+	Data string
+}
+
+// Source returns where n was parsed from, or nil if unknown
+func (f *Fragment) Source(n *html.Node) *Source {
+	return f.Sources[n]
+}
+
+// Position returns where the start tag of n was parsed from, or nil if unknown
+func (f *Fragment) Position(n *html.Node) *Position {
+	if src := f.Source(n); src != nil {
+		return &src.Start
+	}
+	return nil
+}
+
+// SetSource records where n was parsed from
+func (f *Fragment) SetSource(n *html.Node, src *Source) {
+	if f.Sources == nil {
+		f.Sources = make(map[*html.Node]*Source)
+	}
+	f.Sources[n] = src
+}
+
+// adoptSources copies the sources of another fragment's nodes into f
+func (f *Fragment) adoptSources(other *Fragment) {
+	for n, src := range other.Sources {
+		f.SetSource(n, src)
+	}
+}
+
+// lineIndex converts byte offsets in a file to positions
+type lineIndex struct {
+	file   string
+	starts []int
+}
+
+func newLineIndex(file string, data []byte) *lineIndex {
+	starts := []int{0}
+	for i, b := range data {
+		if b == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return &lineIndex{file: file, starts: starts}
+}
+
+func (l *lineIndex) position(offset int) Position {
+	line := sort.Search(len(l.starts), func(i int) bool {
+		return l.starts[i] > offset
+	}) - 1
+	return Position{File: l.file, Line: line + 1, Col: offset - l.starts[line] + 1}
+}
+
+// tagSignature identifies a start tag by its name and attributes, in a form
+// that matches both tokens and parsed elements. Attributes are compared as a
+// set, since the parser sorts those of formatting elements such as <a>.
+func tagSignature(name string, attrs []html.Attribute) string {
+	parts := make([]string, 0, len(attrs))
+	for _, attr := range attrs {
+		parts = append(parts, strings.ToLower(attrName(attr))+"="+attr.Val)
+	}
+	sort.Strings(parts)
+	return strings.Join(append([]string{strings.ToLower(name)}, parts...), "\x00")
+}
+
+// sourceOrder returns attrs in the order of the matching attributes of src
+func sourceOrder(attrs []html.Attribute, src []html.Attribute) []html.Attribute {
+	ordered := make([]html.Attribute, 0, len(attrs))
+	used := make([]bool, len(attrs))
+	for _, orig := range src {
+		for i, attr := range attrs {
+			if !used[i] && strings.EqualFold(orig.Key, attrName(attr)) && orig.Val == attr.Val {
+				ordered = append(ordered, attr)
+				used[i] = true
+				break
+			}
+		}
+	}
+	if len(ordered) != len(attrs) {
+		return attrs
+	}
+	return ordered
+}
+
+// scanSources tokenizes data and returns the sources of its start tags and
+// text, grouped by signature in document order, along with the source of its
+// doctype, if any
+func scanSources(file string, data []byte) (map[string][]*Source, string) {
+	index := newLineIndex(file, data)
+	sources := make(map[string][]*Source)
+	doctype := ""
+	z := html.NewTokenizer(bytes.NewReader(data))
+	offset := 0
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		// Raw must be copied before Token, which unescapes in place
+		raw := string(z.Raw())
+		start := offset
+		offset += len(raw)
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := z.Token()
+			key := tagSignature(token.Data, token.Attr)
+			src := &Source{
+				Start:       index.position(start),
+				Content:     index.position(offset),
+				Raw:         raw,
+				SelfClosing: tt == html.SelfClosingTagToken,
+				Attr:        token.Attr,
+			}
+			if rawAttr := splitAttrs(raw); len(rawAttr) == len(token.Attr) {
+				src.RawAttr = rawAttr
+			}
+			sources[key] = append(sources[key], src)
+		case html.TextToken:
+			token := z.Token()
+			key := textSignature(token.Data)
+			sources[key] = append(sources[key], &Source{
+				Start:   index.position(start),
+				Content: index.position(start),
+				Raw:     raw,
+				Data:    token.Data,
+			})
+		case html.DoctypeToken:
+			if doctype == "" {
+				doctype = raw
+			}
+		}
+	}
+	return sources, doctype
+}
+
+// textSignature identifies a text token or node by its decoded text
+func textSignature(data string) string {
+	return "\x00text\x00" + data
+}
+
+// attachSources records the source of every element and text node in f that
+// can be matched to a token in data. Nodes the parser implied have no source.
+func (f *Fragment) attachSources(file string, data []byte) {
+	sources, doctype := scanSources(file, data)
+	f.Doctype = doctype
+	f.eachNode(func(n *html.Node) {
+		DFS(n, func(n *html.Node) {
+			var key string
+			switch n.Type {
+			case html.ElementNode:
+				key = tagSignature(n.Data, n.Attr)
+			case html.TextNode:
+				key = textSignature(n.Data)
+			default:
+				return
+			}
+			if queue := sources[key]; len(queue) > 0 {
+				f.SetSource(n, queue[0])
+				sources[key] = queue[1:]
+				if n.Type == html.ElementNode {
+					n.Attr = sourceOrder(n.Attr, queue[0].Attr)
+				}
+			}
+		}, nil)
+	})
+}
+
+// splitAttrs returns the source text of each attribute in the source of a
+// start tag, following the tokenizer's rules
+func splitAttrs(raw string) []string {
+	isSpace := func(c byte) bool {
+		return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+	}
+	i := 1
+	for i < len(raw) && !isSpace(raw[i]) && raw[i] != '/' && raw[i] != '>' {
+		i++
+	}
+	attrs := make([]string, 0)
+	for {
+		for i < len(raw) && (isSpace(raw[i]) || raw[i] == '/') {
+			i++
+		}
+		if i >= len(raw) || raw[i] == '>' {
+			return attrs
+		}
+		start := i
+		i++
+		for i < len(raw) && !isSpace(raw[i]) && raw[i] != '/' && raw[i] != '>' && raw[i] != '=' {
+			i++
+		}
+		j := i
+		for j < len(raw) && isSpace(raw[j]) {
+			j++
+		}
+		if j < len(raw) && raw[j] == '=' {
+			j++
+			for j < len(raw) && isSpace(raw[j]) {
+				j++
+			}
+			if j < len(raw) && (raw[j] == '"' || raw[j] == '\'') {
+				end := strings.IndexByte(raw[j+1:], raw[j])
+				if end < 0 {
+					j = len(raw)
+				} else {
+					j += end + 2
+				}
+			} else {
+				for j < len(raw) && !isSpace(raw[j]) && raw[j] != '>' {
+					j++
+				}
+			}
+			i = j
+		}
+		attrs = append(attrs, raw[start:i])
+	}
+}
+
+// readAll reads r into memory so that it can be both parsed and tokenized
+func readAll(r io.Reader) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	_, err := buf.ReadFrom(r)
+	return buf.Bytes(), err
+}
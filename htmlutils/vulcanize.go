@@ -30,3 +30,13 @@ func IsImport(n *html.Node) bool {
 	}
 	return false
 }
+
+// IsStylesheet returns true if the given html node matches link[rel="stylesheet"][href]
+func IsStylesheet(n *html.Node) bool {
+	if n.Type == html.ElementNode && n.Data == "link" {
+		relType, relOk := Attr(n, "rel")
+		_, hasHref := Attr(n, "href")
+		return relOk && relType == "stylesheet" && hasHref
+	}
+	return false
+}
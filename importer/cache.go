@@ -0,0 +1,279 @@
+package importer
+
+import (
+	"bytes"
+	"code.google.com/p/go.net/html"
+	"code.google.com/p/go.net/html/atom"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tbuckley/vulcanize/htmlutils"
+)
+
+// Cache keeps parsed, path-resolved fragments between runs so that only files
+// that changed are parsed again. A file is unchanged if its modification time
+// and size match, or failing that, if its content hash matches.
+//
+// Fragments with their local stylesheets inlined are kept too, so a file is
+// only re-inlined when it or one of its stylesheets changed. These are only
+// kept in memory. Imports are still composed, and scripts inlined, on every
+// run.
+type Cache struct {
+	// Dir, when set, persists parsed fragments to disk so they outlive the
+	// process
+	Dir string
+	// Hits and Misses count lookups of parsed fragments since the cache was
+	// created
+	Hits, Misses int
+	// InlinedHits and InlinedMisses count lookups of inlined fragments
+	InlinedHits, InlinedMisses int
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	inlined map[string]*inlinedEntry
+}
+
+// cacheEntry is a fragment along with the version of the file it came from
+type cacheEntry struct {
+	Name    string
+	ModTime time.Time
+	Size    int64
+	Hash    string
+	Nodes   []cacheNode
+	Doctype string
+
+	doc *htmlutils.Fragment
+}
+
+// inlinedEntry is a fragment with its stylesheets inlined, along with the
+// versions of the file and of each stylesheet it was built from
+type inlinedEntry struct {
+	files []*cacheEntry
+	doc   *htmlutils.Fragment
+}
+
+// cacheNode is the serialized form of an html.Node
+type cacheNode struct {
+	Type      html.NodeType
+	Data      string
+	Namespace string
+	Attr      []html.Attribute
+	Source    *htmlutils.Source
+	Children  []cacheNode
+}
+
+// NewCache creates an empty in-memory cache
+func NewCache() *Cache {
+	return &Cache{
+		entries: make(map[string]*cacheEntry),
+		inlined: make(map[string]*inlinedEntry),
+	}
+}
+
+// get returns a copy of the cached fragment for key, attached to parent, or
+// nil if the file has changed since it was cached
+func (c *Cache) get(fsys fs.FS, key string, name string, parent *html.Node) *htmlutils.Fragment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		e = c.readEntry(key)
+	}
+	if e == nil || e.Name != name || !c.fresh(fsys, e) {
+		c.Misses++
+		return nil
+	}
+	if e.doc == nil {
+		e.doc = decodeFragment(e.Nodes)
+		e.doc.Doctype = e.Doctype
+	}
+	c.entries[key] = e
+	c.Hits++
+	return e.doc.Clone(parent)
+}
+
+// put stores a copy of doc, parsed from content, under key
+func (c *Cache) put(fsys fs.FS, key string, name string, content []byte, doc *htmlutils.Fragment) {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return
+	}
+	e := &cacheEntry{
+		Name:    name,
+		ModTime: info.ModTime(),
+		Size:    info.Size(),
+		Hash:    hash(content),
+		doc:     doc.Clone(nil),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = e
+	c.writeEntry(key, e)
+}
+
+// getInlined returns a copy of the inlined fragment for key, attached to
+// parent, or nil if name or one of its sheets has changed since it was cached
+func (c *Cache) getInlined(fsys fs.FS, key string, name string, sheets []string, parent *html.Node) *htmlutils.Fragment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.inlined[key]
+	if !ok || len(e.files) != len(sheets)+1 || e.files[0].Name != name {
+		c.InlinedMisses++
+		return nil
+	}
+	for j, sheet := range sheets {
+		if e.files[j+1].Name != sheet {
+			c.InlinedMisses++
+			return nil
+		}
+	}
+	for _, f := range e.files {
+		if !c.fresh(fsys, f) {
+			c.InlinedMisses++
+			return nil
+		}
+	}
+	c.InlinedHits++
+	return e.doc.Clone(parent)
+}
+
+// putInlined stores a copy of doc, built from name and sheets, under key
+func (c *Cache) putInlined(fsys fs.FS, key string, name string, sheets []string, doc *htmlutils.Fragment) {
+	e := &inlinedEntry{doc: doc.Clone(nil)}
+	for _, f := range append([]string{name}, sheets...) {
+		info, err := fs.Stat(fsys, f)
+		if err != nil {
+			return
+		}
+		content, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return
+		}
+		e.files = append(e.files, &cacheEntry{
+			Name:    f,
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+			Hash:    hash(content),
+		})
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inlined[key] = e
+}
+
+// fresh returns true if the file behind e is unchanged, updating the stored
+// modification time if only that changed
+func (c *Cache) fresh(fsys fs.FS, e *cacheEntry) bool {
+	info, err := fs.Stat(fsys, e.Name)
+	if err != nil {
+		return false
+	}
+	if info.ModTime().Equal(e.ModTime) && info.Size() == e.Size {
+		return true
+	}
+	content, err := fs.ReadFile(fsys, e.Name)
+	if err != nil || hash(content) != e.Hash {
+		return false
+	}
+	e.ModTime = info.ModTime()
+	e.Size = info.Size()
+	return true
+}
+
+// entryFile returns the file an entry is persisted to
+func (c *Cache) entryFile(key string) string {
+	return filepath.Join(c.Dir, hash([]byte(key))+".gob")
+}
+
+func (c *Cache) readEntry(key string) *cacheEntry {
+	if c.Dir == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(c.entryFile(key))
+	if err != nil {
+		return nil
+	}
+	e := new(cacheEntry)
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(e); err != nil {
+		return nil
+	}
+	return e
+}
+
+// writeEntry persists e. Failures only cost a re-parse, so they are ignored.
+func (c *Cache) writeEntry(key string, e *cacheEntry) {
+	if c.Dir == "" {
+		return
+	}
+	e.Nodes = encodeFragment(e.doc)
+	e.Doctype = e.doc.Doctype
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(e); err != nil {
+		return
+	}
+	if err := os.MkdirAll(c.Dir, 0775); err != nil {
+		return
+	}
+	ioutil.WriteFile(c.entryFile(key), buf.Bytes(), 0664)
+}
+
+func hash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func encodeFragment(doc *htmlutils.Fragment) []cacheNode {
+	nodes := make([]cacheNode, 0)
+	for n := doc.FirstNode; n != nil; n = n.NextSibling {
+		nodes = append(nodes, encodeNode(doc, n))
+	}
+	return nodes
+}
+
+func encodeNode(doc *htmlutils.Fragment, n *html.Node) cacheNode {
+	c := cacheNode{Type: n.Type, Data: n.Data, Namespace: n.Namespace, Attr: n.Attr, Source: doc.Source(n)}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.Children = append(c.Children, encodeNode(doc, child))
+	}
+	return c
+}
+
+func decodeFragment(nodes []cacheNode) *htmlutils.Fragment {
+	doc := new(htmlutils.Fragment)
+	for _, c := range nodes {
+		n := decodeNode(doc, c)
+		if doc.LastNode != nil {
+			doc.LastNode.NextSibling = n
+			n.PrevSibling = doc.LastNode
+		} else {
+			doc.FirstNode = n
+		}
+		doc.LastNode = n
+	}
+	return doc
+}
+
+func decodeNode(doc *htmlutils.Fragment, c cacheNode) *html.Node {
+	n := &html.Node{Type: c.Type, Data: c.Data, Namespace: c.Namespace, Attr: c.Attr}
+	if c.Type == html.ElementNode {
+		n.DataAtom = atom.Lookup([]byte(c.Data))
+	}
+	if c.Source != nil {
+		doc.SetSource(n, c.Source)
+	}
+	for _, child := range c.Children {
+		n.AppendChild(decodeNode(doc, child))
+	}
+	return n
+}
@@ -1,35 +1,97 @@
 package importer
 
 import (
+	"bytes"
 	"code.google.com/p/go.net/html"
-	"log"
-	"os"
-	"path/filepath"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
 	"regexp"
+	"strings"
 
+	"github.com/tbuckley/vulcanize/diagnostics"
+	"github.com/tbuckley/vulcanize/fetch"
+	"github.com/tbuckley/vulcanize/graph"
 	"github.com/tbuckley/vulcanize/htmlutils"
 	"github.com/tbuckley/vulcanize/inliner"
 	"github.com/tbuckley/vulcanize/pathresolver"
 )
 
-var (
-	logger *log.Logger
-)
-
-func init() {
-	logger = log.New(os.Stdout, "logger:", log.Lshortfile)
-}
-
 type Importer struct {
+	// AllowCycles turns import cycles into warnings instead of errors. The
+	// import closing the cycle is dropped.
+	AllowCycles bool
+	// Graph, when set, receives every import, stylesheet and script found
+	Graph *graph.Graph
+	// ExcludedScripts marks script nodes in Graph as excluded
+	ExcludedScripts []*regexp.Regexp
+	// Inliner resolves and reads every file, and inlines stylesheets. Set
+	// Inliner.Remote to follow imports of absolute URLs, and
+	// Inliner.ExcludedElements to leave matching imports alone.
+	Inliner *inliner.Inliner
+	// Cache, when set, holds parsed and inlined files between runs
+	Cache *Cache
+	// Logger receives debug messages about each file flattened. New sets it
+	// to a logger that discards everything.
+	Logger *slog.Logger
+	// KeepGoing records imports and stylesheets that cannot be read, and
+	// import cycles, as errors instead of stopping. The tags of unreadable
+	// files are left in place.
+	KeepGoing bool
+
 	read            map[string]bool
+	stack           []ImportLink
+	warnings        []*diagnostics.Diagnostic
+	errors          diagnostics.List
 	excludedImports []*regexp.Regexp
 	excludedSheets  []*regexp.Regexp
 	outputDir       string
 }
 
-// NewImporter creates a new importer using the list of excluded patterns
-func New(excludedImports, excludedSheets []*regexp.Regexp, outputDir string) *Importer {
+// ImportLink is a <link rel="import"> followed by the importer
+type ImportLink struct {
+	// File is the file containing the link
+	File string
+	Href string
+	// Target is the file the link resolved to
+	Target string
+	// Position locates the link in File, when known
+	Position *htmlutils.Position
+}
+
+func (l ImportLink) String() string {
+	location := l.File
+	if l.Position != nil && l.Position.Line > 0 {
+		location = fmt.Sprintf("%s:%d:%d", l.File, l.Position.Line, l.Position.Col)
+	}
+	return fmt.Sprintf("%s: <link rel=\"import\" href=\"%s\">", location, l.Href)
+}
+
+// CycleError reports an import that leads back to a file that is still being
+// imported
+type CycleError struct {
+	// Chain lists the files from the first one flattened to the repeated one
+	Chain []string
+	// Links are the imports that make up the chain
+	Links []ImportLink
+}
+
+func (e *CycleError) Error() string {
+	links := make([]string, 0, len(e.Links))
+	for _, link := range e.Links {
+		links = append(links, link.String())
+	}
+	return fmt.Sprintf("Import cycle %s (%s)", strings.Join(e.Chain, " -> "), strings.Join(links, ", "))
+}
+
+// New creates a new importer reading from fsys using the list of excluded
+// patterns. Filenames and outputDir are slash-separated paths within fsys.
+func New(fsys fs.FS, excludedImports, excludedSheets []*regexp.Regexp, outputDir string) *Importer {
 	return &Importer{
+		Inliner:         inliner.New(fsys, outputDir),
+		Logger:          slog.New(slog.DiscardHandler),
 		read:            make(map[string]bool),
 		excludedImports: excludedImports,
 		excludedSheets:  excludedSheets,
@@ -37,52 +99,167 @@ func New(excludedImports, excludedSheets []*regexp.Regexp, outputDir string) *Im
 	}
 }
 
-// Flatten flattens out all of the imports from a document
+// Flatten flattens out all of the imports from a document. Errors are
+// returned as a *diagnostics.Diagnostic. With KeepGoing, the document is
+// returned along with a diagnostics.List of every error recorded so far.
 func (i *Importer) Flatten(filename string, context *html.Node) (*htmlutils.Fragment, error) {
-	logger.Printf("Flatten: %v", filename)
+	i.addNode(filename, graph.KIND_IMPORT, false)
+	doc, err := i.flatten(ImportLink{Target: filename}, context)
+	if err != nil {
+		return nil, diagnostics.FromError(err)
+	}
+	return doc, i.errors.Err()
+}
+
+// Skip marks files as already imported, so that links to them are removed
+// rather than flattened
+func (i *Importer) Skip(filenames ...string) {
+	for _, filename := range filenames {
+		i.read[filename] = true
+	}
+}
+
+// Warnings returns the problems found so far that did not stop flattening
+func (i *Importer) Warnings() []*diagnostics.Diagnostic {
+	return i.warnings
+}
+
+// flatten flattens the file targeted by link, tracking it as in progress
+// until all of its imports have been processed
+func (i *Importer) flatten(link ImportLink, context *html.Node) (*htmlutils.Fragment, error) {
+	filename := link.Target
+	i.Logger.Debug("Flatten", "file", filename)
+	i.stack = append(i.stack, link)
+	defer func() {
+		i.stack = i.stack[:len(i.stack)-1]
+	}()
+
 	doc, err := i.load(filename, context)
 	if err != nil {
 		return nil, err
 	}
-	err = i.processImports(doc, i.outputDir)
+	err = i.processImports(doc, filename)
 	return doc, err
 }
 
 // load returns an HTML fragment representing the contents of the given file
 // and ensures that the same file isn't loaded multiple times
 func (i *Importer) load(filename string, context *html.Node) (*htmlutils.Fragment, error) {
-	doc, err := htmlutils.FromFile(filename, context)
+	doc, err := i.parse(filename, context)
 	if err != nil {
-		return nil, err
+		return nil, diagnostics.ReadError(filename, err)
+	}
+
+	i.recordAssets(doc, filename)
+
+	// Reuse the inlined fragment if neither the file nor its stylesheets changed
+	key := i.cacheKey(filename, context)
+	var sheets []string
+	cached := i.Cache != nil && !fetch.IsRemote(filename)
+	if cached {
+		sheets = i.Inliner.Sheets(doc, i.excludedSheets)
+		for _, sheet := range sheets {
+			cached = cached && !fetch.IsRemote(sheet)
+		}
+	}
+	if cached {
+		if inlined := i.Cache.getInlined(i.Inliner.FS, key, filename, sheets, context); inlined != nil {
+			i.read[filename] = true
+			return inlined, nil
+		}
 	}
 
-	dir := filepath.Dir(filename)
-	pathresolver.ResolvePaths(doc, dir, i.outputDir)
-	err = inliner.InlineSheets(doc, i.outputDir, i.excludedSheets)
+	i.Inliner.KeepGoing = i.KeepGoing
+	err = i.Inliner.InlineSheets(doc, i.excludedSheets)
+	var errs diagnostics.List
+	if errors.As(err, &errs) {
+		for _, d := range errs {
+			i.errors = append(i.errors, i.diagnose(d, doc, nil, filename))
+		}
+	} else if err != nil {
+		return nil, i.diagnose(err, doc, nil, filename)
+	} else if cached {
+		i.Cache.putInlined(i.Inliner.FS, key, filename, sheets, doc)
+	}
+
+	i.read[filename] = true
+	return doc, nil
+}
+
+// cacheKey identifies the fragment for filename in Cache
+func (i *Importer) cacheKey(filename string, context *html.Node) string {
+	return fmt.Sprintf("%s\x00%s\x00%t", filename, i.outputDir, context == nil)
+}
+
+// parse returns the path-resolved contents of the given file, from the cache
+// if it is unchanged
+func (i *Importer) parse(filename string, context *html.Node) (*htmlutils.Fragment, error) {
+	remote := fetch.IsRemote(filename)
+	key := i.cacheKey(filename, context)
+	if i.Cache != nil && !remote {
+		if doc := i.Cache.get(i.Inliner.FS, key, filename, context); doc != nil {
+			return doc, nil
+		}
+	}
+
+	content, err := i.Inliner.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := htmlutils.Parse(bytes.NewReader(content), filename, context)
 	if err != nil {
 		return nil, err
 	}
 
-	i.read[filename] = true
+	if remote {
+		pathresolver.ResolveRemotePaths(doc, filename)
+	} else {
+		pathresolver.ResolvePaths(doc, path.Dir(filename), i.outputDir)
+		if i.Cache != nil {
+			i.Cache.put(i.Inliner.FS, key, filename, content, doc)
+		}
+	}
 	return doc, nil
 }
 
 // processImports iterates over the imports in a document, inlining available
 // ones and skipping those that have been excluded
-func (i *Importer) processImports(doc *htmlutils.Fragment, outputDir string) error {
+func (i *Importer) processImports(doc *htmlutils.Fragment, filename string) error {
 	imports := doc.Search(htmlutils.IsImport)
 	for _, imp := range imports {
 		href, ok := htmlutils.Attr(imp, "href")
-		logger.Printf("Href: %v", href)
-		if ok && !inliner.IsExcluded(href, i.excludedImports) {
-			importFile := filepath.Join(outputDir, href)
-			logger.Printf("importFile: %v", importFile)
-			if i.deduplicateImport(importFile) {
+		if !ok {
+			continue
+		}
+		if importFile, ok := i.Inliner.Resolve(href, i.excludedImports); !ok || i.Inliner.IsExcludedElement(imp) {
+			i.addNode(href, graph.KIND_IMPORT, true)
+			i.addEdge(filename, href, imp)
+		} else {
+			i.addNode(importFile, graph.KIND_IMPORT, false)
+			i.addEdge(filename, importFile, imp)
+			i.Logger.Debug("Import", "href", href, "file", importFile)
+			link := ImportLink{File: filename, Href: href, Target: importFile, Position: doc.Position(imp)}
+			if err := i.detectCycle(link); err != nil {
+				d := i.diagnose(diagnostics.Errorf(diagnostics.CODE_IMPORT_CYCLE, "%v", err).Wrap(err), doc, imp, filename)
+				switch {
+				case i.AllowCycles:
+					d.Severity = diagnostics.SEVERITY_WARNING
+					i.warnings = append(i.warnings, d)
+				case i.KeepGoing:
+					i.errors = append(i.errors, d)
+				default:
+					return d
+				}
+				htmlutils.RemoveNode(doc, imp)
+			} else if i.deduplicateImport(importFile) {
 				htmlutils.RemoveNode(doc, imp)
 			} else {
-				content, err := i.Flatten(importFile, imp.Parent)
-				if err != nil {
-					return err
+				content, err := i.flatten(link, imp.Parent)
+				if err != nil && i.KeepGoing {
+					i.errors = append(i.errors, i.diagnose(err, doc, imp, filename))
+					continue
+				} else if err != nil {
+					return i.diagnose(err, doc, imp, filename)
 				}
 				htmlutils.ReplaceNodeWithFragment(doc, imp, content)
 			}
@@ -91,6 +268,99 @@ func (i *Importer) processImports(doc *htmlutils.Fragment, outputDir string) err
 	return nil
 }
 
+// diagnose turns err into a diagnostic located at n in filename, unless it
+// was already located in a file imported from there
+func (i *Importer) diagnose(err error, doc *htmlutils.Fragment, n *html.Node, filename string) *diagnostics.Diagnostic {
+	d := diagnostics.FromError(err)
+	if d.File != "" && d.Chain != nil {
+		return d
+	}
+	if d.File == "" && n != nil {
+		d.At(doc.Position(n))
+	}
+	d.InFile(filename)
+	d.Chain = make([]string, 0, len(i.stack))
+	for _, frame := range i.stack {
+		d.Chain = append(d.Chain, frame.Target)
+	}
+	return d
+}
+
+// detectCycle returns a CycleError if following link would import a file that
+// is still being imported
+func (i *Importer) detectCycle(link ImportLink) error {
+	for start, frame := range i.stack {
+		if frame.Target == link.Target {
+			chain := make([]string, 0, len(i.stack)+1)
+			for _, f := range i.stack {
+				chain = append(chain, f.Target)
+			}
+			chain = append(chain, link.Target)
+
+			links := make([]ImportLink, 0, len(i.stack)-start)
+			for _, f := range i.stack[start+1:] {
+				links = append(links, f)
+			}
+			links = append(links, link)
+			return &CycleError{Chain: chain, Links: links}
+		}
+	}
+	return nil
+}
+
+// recordAssets adds the stylesheets and scripts referenced by doc to the graph
+func (i *Importer) recordAssets(doc *htmlutils.Fragment, filename string) {
+	if i.Graph == nil {
+		return
+	}
+	assets := []struct {
+		kind     string
+		attr     string
+		pred     htmlutils.HTMLPred
+		excludes []*regexp.Regexp
+	}{
+		{graph.KIND_STYLESHEET, "href", htmlutils.IsStylesheet, i.excludedSheets},
+		{graph.KIND_SCRIPT, "src", htmlutils.AndP(htmlutils.HasTagnameP("script"), htmlutils.HasAttrP("src")), i.ExcludedScripts},
+	}
+	for _, asset := range assets {
+		for _, n := range doc.Search(asset.pred) {
+			ref, _ := htmlutils.Attr(n, asset.attr)
+			if target, ok := i.Inliner.Resolve(ref, asset.excludes); !ok || i.Inliner.IsExcludedElement(n) {
+				i.addNode(ref, asset.kind, true)
+				i.addEdge(filename, ref, n)
+			} else {
+				i.addNode(target, asset.kind, false)
+				i.addEdge(filename, target, n)
+			}
+		}
+	}
+}
+
+// addNode adds a file to the graph, looking up its size unless it is excluded
+func (i *Importer) addNode(id, kind string, excluded bool) {
+	if i.Graph == nil || i.Graph.Node(id) != nil {
+		return
+	}
+	var size int64
+	if fetch.IsRemote(id) && !excluded {
+		if content, err := i.Inliner.ReadFile(id); err == nil {
+			size = int64(len(content))
+		}
+	} else if !excluded {
+		if info, err := fs.Stat(i.Inliner.FS, id); err == nil {
+			size = info.Size()
+		}
+	}
+	i.Graph.AddNode(id, kind, size, excluded)
+}
+
+// addEdge records that the file from references to through element n
+func (i *Importer) addEdge(from, to string, n *html.Node) {
+	if i.Graph != nil {
+		i.Graph.AddEdge(from, to, htmlutils.StartTag(n))
+	}
+}
+
 // deduplicateImport returns true if filename has already been imported
 func (i *Importer) deduplicateImport(filename string) bool {
 	_, ok := i.read[filename]
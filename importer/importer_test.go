@@ -1,22 +1,67 @@
 package importer
 
 import (
+	"bytes"
 	"code.google.com/p/go.net/html"
+	"errors"
+	"github.com/tbuckley/vulcanize/diagnostics"
+	"github.com/tbuckley/vulcanize/graph"
 	"github.com/tbuckley/vulcanize/htmlutils"
+	"log/slog"
 	"regexp"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
+var testFS = fstest.MapFS{
+	"test/index.html": &fstest.MapFile{Data: []byte(`<!doctype html>
+<html>
+<head>
+  <link rel="import" href="a.html" />
+  <link rel="import" href="b.html" />
+</head>
+
+<body>
+  <foo-a></foo-a>
+  <foo-b></foo-b>
+</body>
+</html>`)},
+	"test/a.html": &fstest.MapFile{Data: []byte(`<link rel="import" href="b.html">
+
+<polymer-element name="foo-a">
+  <template>
+    FOO
+    <foo-b></foo-b>
+  </template>
+  <script>
+    Polymer("foo-a", {});
+  </script>
+</polymer-element>`)},
+	"test/b.html": &fstest.MapFile{Data: []byte(`<polymer-element name="foo-b">
+  <template>
+    <style>
+      :host {background-image:url('bkg.png');}
+    </style>
+    BAR
+  </template>
+  <script>
+    Polymer({});
+  </script>
+</polymer-element>`)},
+}
+
 func TestNewImporter(t *testing.T) {
 	re1 := regexp.MustCompilePOSIX("href.*")
 	re2 := regexp.MustCompilePOSIX("data.*")
-	i := New([]*regexp.Regexp{re1, re2}, "./")
+	i := New(testFS, []*regexp.Regexp{re1, re2}, nil, "./")
 
 	if i == nil {
 		t.Error("returned importer is null")
 	}
 
-	if len(i.excludedPatterns) != 2 {
+	if len(i.excludedImports) != 2 {
 		t.Error("returned importer does not have excluded patterns")
 	}
 
@@ -26,13 +71,13 @@ func TestNewImporter(t *testing.T) {
 }
 
 func TestImporter_Flatten(t *testing.T) {
-	i := New(nil, "./")
+	i := New(testFS, nil, nil, "test")
 
-	doc, err := i.Flatten("../test/index.html", nil)
-	t.Log(doc.String())
+	doc, err := i.Flatten("test/index.html", nil)
 	if err != nil {
-		t.Error(err.Error())
+		t.Fatal(err.Error())
 	}
+	t.Log(doc.String())
 
 	var els []*html.Node
 
@@ -57,18 +102,233 @@ func TestImporter_Flatten(t *testing.T) {
 	}
 }
 
-func TestImporter_load(t *testing.T) {
+func TestImporter_Graph(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`<link rel="import" href="a.html"><link rel="import" href="http://cdn/x.html">`)},
+		"a.html":     &fstest.MapFile{Data: []byte(`<link rel="stylesheet" href="a.css"><script src="a.js"></script>`)},
+		"a.css":      &fstest.MapFile{Data: []byte(`p {}`)},
+		"a.js":       &fstest.MapFile{Data: []byte(`x();`)},
+	}
+	excludes := []*regexp.Regexp{regexp.MustCompile("^http")}
+
+	i := New(fsys, excludes, nil, ".")
+	i.Graph = graph.New()
+	if _, err := i.Flatten("index.html", nil); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := map[string]graph.Node{
+		"index.html":        {ID: "index.html", Kind: graph.KIND_IMPORT, Size: int64(len(fsys["index.html"].Data))},
+		"a.html":            {ID: "a.html", Kind: graph.KIND_IMPORT, Size: int64(len(fsys["a.html"].Data))},
+		"http://cdn/x.html": {ID: "http://cdn/x.html", Kind: graph.KIND_IMPORT, Excluded: true},
+		"a.css":             {ID: "a.css", Kind: graph.KIND_STYLESHEET, Size: 4},
+		"a.js":              {ID: "a.js", Kind: graph.KIND_SCRIPT, Size: 4},
+	}
+	for id, node := range expected {
+		n := i.Graph.Node(id)
+		if n == nil || *n != node {
+			t.Errorf("Expected %v, got %v", node, n)
+		}
+	}
+	if len(i.Graph.Edges) != 4 {
+		t.Errorf("Expected 4 edges, got %v", len(i.Graph.Edges))
+	}
+}
+
+func TestImporter_cycles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`<link rel="import" href="a.html">`)},
+		"a.html":     &fstest.MapFile{Data: []byte(`<link rel="import" href="b.html"><p>a</p>`)},
+		"b.html":     &fstest.MapFile{Data: []byte("<p>b</p>\n  <link rel=\"import\" href=\"a.html\">")},
+	}
 
+	i := New(fsys, nil, nil, ".")
+	_, err := i.Flatten("index.html", nil)
+	var cycle *CycleError
+	if !errors.As(err, &cycle) {
+		t.Fatalf("Expected a CycleError, got %v", err)
+	}
+	expected := "Import cycle index.html -> a.html -> b.html -> a.html " +
+		"(a.html:1:1: <link rel=\"import\" href=\"b.html\">, b.html:2:3: <link rel=\"import\" href=\"a.html\">)"
+	if cycle.Error() != expected {
+		t.Errorf("Expected %v, got %v", expected, cycle.Error())
+	}
+
+	i = New(fsys, nil, nil, ".")
+	i.AllowCycles = true
+	doc, err := i.Flatten("index.html", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(i.Warnings()) != 1 {
+		t.Errorf("Expected 1 warning, got %v", i.Warnings())
+	}
+	if len(doc.Search(htmlutils.HasTagnameP("p"))) != 2 {
+		t.Errorf("Expected both files to be flattened, got %v", doc.String())
+	}
+	if len(doc.Search(htmlutils.IsImport)) != 0 {
+		t.Errorf("Import closing the cycle was left in the document: %v", doc.String())
+	}
+}
+
+func TestImporter_diagnostics(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`<link rel="import" href="a.html">`)},
+		"a.html":     &fstest.MapFile{Data: []byte("<p>a</p>\n  <link rel=\"import\" href=\"missing.html\">")},
+	}
+
+	i := New(fsys, nil, nil, ".")
+	_, err := i.Flatten("index.html", nil)
+	var d *diagnostics.Diagnostic
+	if !errors.As(err, &d) {
+		t.Fatalf("Expected a Diagnostic, got %v", err)
+	}
+	if d.Code != diagnostics.CODE_MISSING_FILE {
+		t.Errorf("Expected code %v, got %v", diagnostics.CODE_MISSING_FILE, d.Code)
+	}
+	if d.Location() != "a.html:2:3" {
+		t.Errorf("Expected a.html:2:3, got %v", d.Location())
+	}
+	if strings.Join(d.Chain, " ") != "index.html a.html" {
+		t.Errorf("Expected chain index.html a.html, got %v", d.Chain)
+	}
 }
 
-func TestImporter_processImports(t *testing.T) {
+func TestImporter_Logger(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`<link rel="import" href="a.html">`)},
+		"a.html":     &fstest.MapFile{Data: []byte(`<p>a</p>`)},
+	}
 
+	buf := new(bytes.Buffer)
+	i := New(fsys, nil, nil, ".")
+	i.Logger = slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	if _, err := i.Flatten("index.html", nil); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, expected := range []string{"msg=Flatten file=index.html", "msg=Import href=a.html file=a.html"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected %v in log, got %v", expected, buf.String())
+		}
+	}
 }
 
 func TestImporter_excludeImport(t *testing.T) {
+	i := New(testFS, []*regexp.Regexp{regexp.MustCompile("b\\.html$")}, nil, "test")
+	i.Graph = graph.New()
+	doc, err := i.Flatten("test/index.html", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
 
+	html := doc.String()
+	if strings.Contains(html, `<polymer-element name="foo-b"`) {
+		t.Errorf("Expected b.html not to be flattened, got %v", html)
+	}
+	if strings.Count(html, `<link rel="import" href="b.html"`) != 2 {
+		t.Errorf("Expected both imports of b.html to be kept, got %v", html)
+	}
+	if n := i.Graph.Node("b.html"); n == nil || !n.Excluded {
+		t.Errorf("Expected b.html to be excluded in the graph, got %v", n)
+	}
 }
 
 func TestImporter_deduplicateImport(t *testing.T) {
+	i := New(testFS, nil, nil, "test")
+	if i.deduplicateImport("test/b.html") {
+		t.Error("Expected b.html not to have been imported yet")
+	}
+	doc, err := i.Flatten("test/index.html", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// b.html is imported by both index.html and a.html
+	if count := strings.Count(doc.String(), `<polymer-element name="foo-b"`); count != 1 {
+		t.Errorf("Expected b.html to be flattened once, got %v times", count)
+	}
+	if !i.deduplicateImport("test/b.html") {
+		t.Error("Expected b.html to have been imported")
+	}
+}
 
+func TestImporter_Cache(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`<link rel="import" href="a.html"><link rel="import" href="b.html">`)},
+		"a.html":     &fstest.MapFile{Data: []byte(`<p>a</p>`)},
+		"b.html":     &fstest.MapFile{Data: []byte(`<p>b</p>`)},
+	}
+
+	for _, dir := range []string{"", t.TempDir()} {
+		cache := NewCache()
+		cache.Dir = dir
+		flatten := func() string {
+			i := New(fsys, nil, nil, ".")
+			i.Cache = cache
+			doc, err := i.Flatten("index.html", nil)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			return doc.String()
+		}
+
+		first := flatten()
+		if second := flatten(); second != first || cache.Hits != 3 {
+			t.Errorf("Expected identical output from the cache with 3 hits, got %v (%v hits)", second, cache.Hits)
+		}
+
+		// Touching a file without changing it is still a hit
+		fsys["a.html"].ModTime = fsys["a.html"].ModTime.Add(time.Second)
+		flatten()
+		if cache.Misses != 3 {
+			t.Errorf("Expected unchanged content to hit the cache, got %v misses", cache.Misses)
+		}
+
+		fsys["b.html"].Data = []byte(`<p>changed</p>`)
+		if output := flatten(); !strings.Contains(output, "changed") || cache.Misses != 4 {
+			t.Errorf("Expected only b.html to be parsed again, got %v (%v misses)", output, cache.Misses)
+		}
+		fsys["b.html"].Data = []byte(`<p>b</p>`)
+
+		if dir != "" {
+			// A new cache sharing the directory picks up the persisted
+			// entries, except for b.html which changed back
+			cache = NewCache()
+			cache.Dir = dir
+			if output := flatten(); output != first || cache.Hits != 2 {
+				t.Errorf("Expected persisted entries to be used, got %v (%v hits)", output, cache.Hits)
+			}
+		}
+	}
+}
+
+func TestImporter_CacheSheets(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`<link rel="import" href="a.html"><link rel="import" href="b.html">`)},
+		"a.html":     &fstest.MapFile{Data: []byte(`<link rel="stylesheet" href="a.css"><p>a</p>`)},
+		"a.css":      &fstest.MapFile{Data: []byte(`p { color: red; }`)},
+		"b.html":     &fstest.MapFile{Data: []byte(`<p>b</p>`)},
+	}
+	cache := NewCache()
+	flatten := func() string {
+		i := New(fsys, nil, nil, ".")
+		i.Cache = cache
+		doc, err := i.Flatten("index.html", nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return doc.String()
+	}
+
+	first := flatten()
+	if second := flatten(); second != first || cache.InlinedHits != 3 {
+		t.Errorf("Expected identical output from the cache with 3 inlined hits, got %v (%v hits)", second, cache.InlinedHits)
+	}
+
+	// Changing a stylesheet inlines the file that links it again, even
+	// though the file itself is unchanged
+	fsys["a.css"].Data = []byte(`p { color: blue; }`)
+	if output := flatten(); !strings.Contains(output, "blue") || cache.InlinedMisses != 4 || cache.Misses != 3 {
+		t.Errorf("Expected only a.html to be inlined again, got %v (%v inlined misses, %v misses)", output, cache.InlinedMisses, cache.Misses)
+	}
 }
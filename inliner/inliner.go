@@ -2,12 +2,23 @@ package inliner
 
 import (
 	"code.google.com/p/go.net/html"
-	"io/ioutil"
-	"path/filepath"
+	"io/fs"
+	"path"
 	"regexp"
 
+	"github.com/tbuckley/vulcanize/diagnostics"
+	"github.com/tbuckley/vulcanize/fetch"
 	"github.com/tbuckley/vulcanize/htmlutils"
 	"github.com/tbuckley/vulcanize/pathresolver"
+	"github.com/tbuckley/vulcanize/sri"
+)
+
+var (
+	EXTERNAL_SCRIPT = htmlutils.MustCompile(`script:not([type])[src], script[type="text/javascript"][src]`)
+	STYLESHEET_LINK = htmlutils.MustCompile(`link[rel="stylesheet"]`)
+	// LINK_ONLY_ATTRS are not copied from a stylesheet link to the <style>
+	// replacing it
+	LINK_ONLY_ATTRS = map[string]bool{"rel": true, "href": true, "integrity": true, "crossorigin": true}
 )
 
 func IsExcluded(path string, excludes []*regexp.Regexp) bool {
@@ -19,51 +30,150 @@ func IsExcluded(path string, excludes []*regexp.Regexp) bool {
 	return false
 }
 
-func InlineScripts(doc *htmlutils.Fragment, outputDir string, excludes []*regexp.Regexp) error {
-	// script:not([type])[src], script[type="text/javascript"][src]
-	pred := htmlutils.AndP(
-		htmlutils.HasTagnameP("script"),
-		htmlutils.HasAttrP("src"),
-		htmlutils.OrP(
-			htmlutils.NotP(htmlutils.HasAttrP("type")),
-			htmlutils.HasAttrValueP("type", "text/javascript")))
+// Inliner replaces references to scripts and stylesheets with their content
+type Inliner struct {
+	// FS holds local files. Paths in the document are relative to OutputDir.
+	FS        fs.FS
+	OutputDir string
+	// Remote, when set, is used to fetch absolute http(s) URLs
+	Remote *fetch.Remote
+	// KeepGoing leaves tags whose file cannot be read in place, returning
+	// every failure as a diagnostics.List once the document is processed
+	KeepGoing bool
+	// ExcludedElements holds predicates for elements that are left alone,
+	// whatever they reference
+	ExcludedElements []htmlutils.HTMLPred
+	// VerifyIntegrity checks the content of scripts and stylesheets against
+	// their integrity attribute, failing on a mismatch
+	VerifyIntegrity bool
+}
+
+// New creates an inliner reading local files from fsys
+func New(fsys fs.FS, outputDir string) *Inliner {
+	return &Inliner{
+		FS:        fsys,
+		OutputDir: outputDir,
+	}
+}
+
+// Resolve returns the name to read for a reference from the document. ok is
+// false if the reference should be left alone.
+func (in *Inliner) Resolve(ref string, excludes []*regexp.Regexp) (name string, ok bool) {
+	if IsExcluded(ref, excludes) {
+		return "", false
+	}
+	if fetch.IsRemote(ref) {
+		return ref, in.Remote != nil && in.Remote.Allows(ref)
+	}
+	return path.Join(in.OutputDir, ref), true
+}
+
+// Sheets returns the files InlineSheets would inline into doc, in order
+func (in *Inliner) Sheets(doc *htmlutils.Fragment, excludes []*regexp.Regexp) []string {
+	var files []string
+	for _, sheet := range doc.Search(STYLESHEET_LINK) {
+		if filename, ok := in.resolveSheet(sheet, excludes); ok {
+			files = append(files, filename)
+		}
+	}
+	return files
+}
 
-	scripts := doc.Search(pred)
+// resolveSheet returns the file a stylesheet link should be inlined from
+func (in *Inliner) resolveSheet(sheet *html.Node, excludes []*regexp.Regexp) (string, bool) {
+	href, ok := htmlutils.Attr(sheet, "href")
+	if !ok || in.IsExcludedElement(sheet) {
+		return "", false
+	}
+	return in.Resolve(href, excludes)
+}
+
+// IsExcludedElement returns true if n matches one of ExcludedElements
+func (in *Inliner) IsExcludedElement(n *html.Node) bool {
+	for _, pred := range in.ExcludedElements {
+		if pred(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadFile reads a name returned by Resolve
+func (in *Inliner) ReadFile(name string) ([]byte, error) {
+	return fetch.ReadFile(in.FS, in.Remote, name)
+}
+
+// read reads the file referenced by n, checking its integrity attribute if
+// VerifyIntegrity is set
+func (in *Inliner) read(doc *htmlutils.Fragment, n *html.Node, filename string) ([]byte, *diagnostics.Diagnostic) {
+	content, err := in.ReadFile(filename)
+	if err != nil {
+		return nil, diagnostics.ReadError(filename, err).At(doc.Position(n))
+	}
+	if integrity, ok := htmlutils.Attr(n, "integrity"); ok && in.VerifyIntegrity {
+		if err := sri.Verify(integrity, content); err != nil {
+			return nil, diagnostics.Errorf(diagnostics.CODE_INTEGRITY, "Integrity check failed for %s: %v", filename, err).At(doc.Position(n)).Wrap(err)
+		}
+	}
+	return content, nil
+}
+
+// InlineScripts replaces external scripts with inline scripts holding their
+// content
+func (in *Inliner) InlineScripts(doc *htmlutils.Fragment, excludes []*regexp.Regexp) error {
+	var errs diagnostics.List
+	scripts := doc.Search(EXTERNAL_SCRIPT)
 	for _, script := range scripts {
-		src, ok := htmlutils.Attr(script, "src")
-		if ok && !IsExcluded(src, excludes) {
-			filename := filepath.Join(outputDir, src)
-			content, err := ioutil.ReadFile(filename)
-			if err != nil {
-				return err
+		src, _ := htmlutils.Attr(script, "src")
+		if in.IsExcludedElement(script) {
+			continue
+		}
+		if filename, ok := in.Resolve(src, excludes); ok {
+			content, d := in.read(doc, script, filename)
+			if d != nil {
+				if !in.KeepGoing {
+					return d
+				}
+				errs = append(errs, d)
+				continue
 			}
 			inlinedScript := htmlutils.CreateScript(string(content))
 			// @TODO: modify script content?
 			htmlutils.ReplaceNodeWithNode(doc, script, inlinedScript)
+			start := htmlutils.Position{File: filename, Line: 1, Col: 1}
+			doc.SetSource(inlinedScript, &htmlutils.Source{Start: start, Content: start})
 		}
 	}
-	return nil
+	return errs.Err()
 }
 
-func InlineSheets(doc *htmlutils.Fragment, outputDir string, excludes []*regexp.Regexp) error {
-	// link[rel="stylesheet"]
-	pred := htmlutils.AndP(htmlutils.HasTagnameP("link"), htmlutils.HasAttrValueP("rel", "stylesheet"))
-
-	sheets := doc.Search(pred)
+// InlineSheets replaces stylesheet links with <style> blocks holding their
+// content
+func (in *Inliner) InlineSheets(doc *htmlutils.Fragment, excludes []*regexp.Regexp) error {
+	var errs diagnostics.List
+	sheets := doc.Search(STYLESHEET_LINK)
 	for _, sheet := range sheets {
-		href, ok := htmlutils.Attr(sheet, "href")
-		if ok && !IsExcluded(href, excludes) {
-			filename := filepath.Join(outputDir, href)
-			content, err := ioutil.ReadFile(filename)
-			if err != nil {
-				return err
+		if filename, ok := in.resolveSheet(sheet, excludes); ok {
+			content, d := in.read(doc, sheet, filename)
+			if d != nil {
+				if !in.KeepGoing {
+					return d
+				}
+				errs = append(errs, d)
+				continue
 			}
 			stylesheet := string(content)
-			stylesheet = pathresolver.RewriteURL(filepath.Dir(filename), outputDir, stylesheet)
+			if fetch.IsRemote(filename) {
+				stylesheet = pathresolver.RewriteURLFunc(stylesheet, func(rel string) string {
+					return pathresolver.ResolveURL(filename, rel)
+				})
+			} else {
+				stylesheet = pathresolver.RewriteURL(path.Dir(filename), in.OutputDir, stylesheet)
+			}
 			inlinedSheet := htmlutils.CreateStyle(stylesheet)
-			// @TODO: copy link attributes (except rel/href) to style
+			// Copy the link attributes that still apply to the style
 			for _, attr := range sheet.Attr {
-				if attr.Key != "rel" && attr.Key != "href" {
+				if !LINK_ONLY_ATTRS[attr.Key] {
 					inlinedSheet.Attr = append(inlinedSheet.Attr, html.Attribute{
 						Key: attr.Key,
 						Val: attr.Val,
@@ -73,5 +183,5 @@ func InlineSheets(doc *htmlutils.Fragment, outputDir string, excludes []*regexp.
 			htmlutils.ReplaceNodeWithNode(doc, sheet, inlinedSheet)
 		}
 	}
-	return nil
+	return errs.Err()
 }
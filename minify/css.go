@@ -0,0 +1,85 @@
+package minify
+
+import (
+	"bytes"
+	"strings"
+)
+
+// CSS_PUNCTUATION needs no whitespace on either side
+const CSS_PUNCTUATION = "{};,>"
+
+func minifyCSS(src string) (string, error) {
+	buf := new(bytes.Buffer)
+	space := false
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '"' || c == '\'':
+			end, err := skipString(src, i)
+			if err != nil {
+				return "", err
+			}
+			writeCSSSpace(buf, space, c)
+			buf.WriteString(src[i:end])
+			space, i = false, end
+		case c == '/' && strings.HasPrefix(src[i:], "/*"):
+			end, err := skipComment(src, i)
+			if err != nil {
+				return "", err
+			}
+			if comment := src[i:end]; isKeptComment(comment) {
+				writeCSSSpace(buf, space, c)
+				buf.WriteString(comment)
+				space = false
+			} else {
+				// A dropped comment still separates tokens
+				space = true
+			}
+			i = end
+		case isSpace(c):
+			space = true
+			i++
+		default:
+			// Trailing semicolons are dropped
+			if c == '}' {
+				trimSuffix(buf, ";")
+			}
+			// A space before the colon of a declaration can go, but not in
+			// selectors such as div :hover
+			if c == ':' && isDeclaration(src[i:]) {
+				space = false
+			}
+			writeCSSSpace(buf, space, c)
+			buf.WriteByte(c)
+			space = false
+			i++
+		}
+	}
+	return buf.String(), nil
+}
+
+// writeCSSSpace writes the whitespace skipped before next, if it is needed
+func writeCSSSpace(buf *bytes.Buffer, space bool, next byte) {
+	if !space || buf.Len() == 0 || strings.IndexByte(CSS_PUNCTUATION, next) >= 0 {
+		return
+	}
+	prev := buf.Bytes()[buf.Len()-1]
+	if strings.IndexByte(CSS_PUNCTUATION+":", prev) >= 0 {
+		return
+	}
+	buf.WriteByte(' ')
+}
+
+// isDeclaration returns true if the colon starting rest separates a property
+// from its value, rather than starting a pseudo-class
+func isDeclaration(rest string) bool {
+	end := strings.IndexAny(rest, "{;}")
+	return end < 0 || rest[end] != '{'
+}
+
+// trimSuffix removes suffix from the end of buf, if it is there
+func trimSuffix(buf *bytes.Buffer, suffix string) {
+	if bytes.HasSuffix(buf.Bytes(), []byte(suffix)) {
+		buf.Truncate(buf.Len() - len(suffix))
+	}
+}
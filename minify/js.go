@@ -0,0 +1,237 @@
+package minify
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// REGEXP_PRECEDERS may come before a regular expression literal, where a /
+// cannot be a division
+const REGEXP_PRECEDERS = "(,=:[!&|?{};+-*%<>~^"
+
+// REGEXP_KEYWORDS may come before a regular expression literal
+var REGEXP_KEYWORDS = []string{"return", "typeof", "case", "do", "else", "in", "instanceof", "new", "delete", "void", "throw"}
+
+// CONDITION_KEYWORDS are followed by a parenthesized condition, after which a
+// / starts a regular expression
+var CONDITION_KEYWORDS = []string{"if", "while", "for", "with"}
+
+func minifyJS(src string) (string, error) {
+	buf := new(bytes.Buffer)
+	space, newlines := false, 0
+	// parens records, for each open parenthesis, whether it holds the
+	// condition of a keyword, and condition whether the last one closed did
+	parens := make([]bool, 0)
+	condition := false
+	// flush writes the whitespace skipped before next. Line breaks are kept,
+	// other whitespace only where two tokens would otherwise merge.
+	flush := func(next byte) {
+		if newlines > 0 {
+			buf.WriteString(strings.Repeat("\n", newlines))
+		} else if space && buf.Len() > 0 {
+			prev := buf.Bytes()[buf.Len()-1]
+			// Keep a + +b, a - -b and 1 .toString() apart
+			if isIdent(prev) && isIdent(next) || prev == next && strings.IndexByte("+-/", prev) >= 0 ||
+				'0' <= prev && prev <= '9' && next == '.' {
+				buf.WriteByte(' ')
+			}
+		}
+		space, newlines = false, 0
+	}
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '"' || c == '\'':
+			end, err := skipString(src, i)
+			if err != nil {
+				return "", err
+			}
+			flush(c)
+			buf.WriteString(src[i:end])
+			i = end
+		case c == '`':
+			end, err := skipTemplate(src, i)
+			if err != nil {
+				return "", err
+			}
+			flush(c)
+			buf.WriteString(src[i:end])
+			i = end
+		case strings.HasPrefix(src[i:], "//"):
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				end = len(src)
+			} else {
+				end += i
+			}
+			// Source map directives (//# sourceMappingURL=) are kept
+			if strings.HasPrefix(src[i:], "//#") || strings.HasPrefix(src[i:], "//@") {
+				flush(c)
+				buf.WriteString(src[i:end])
+			}
+			i = end
+		case strings.HasPrefix(src[i:], "/*"):
+			end, err := skipComment(src, i)
+			if err != nil {
+				return "", err
+			}
+			comment := src[i:end]
+			if isKeptComment(comment) {
+				flush(c)
+				buf.WriteString(comment)
+			} else if n := strings.Count(comment, "\n"); n > 0 {
+				newlines += n
+			} else {
+				space = true
+			}
+			i = end
+		case c == '/' && isRegexpStart(buf.Bytes(), condition):
+			end, err := skipRegexp(src, i)
+			if err != nil {
+				return "", err
+			}
+			flush(c)
+			buf.WriteString(src[i:end])
+			i = end
+		case c == '\n':
+			newlines++
+			i++
+		case isSpace(c):
+			space = true
+			i++
+		default:
+			switch c {
+			case '(':
+				parens = append(parens, isKeyword(lastWord(buf.Bytes()), CONDITION_KEYWORDS))
+			case ')':
+				condition = false
+				if n := len(parens); n > 0 {
+					condition = parens[n-1]
+					parens = parens[:n-1]
+				}
+			}
+			flush(c)
+			buf.WriteByte(c)
+			i++
+		}
+	}
+	flush(0)
+	return buf.String(), nil
+}
+
+// isRegexpStart returns true if a / following the minified output so far
+// starts a regular expression rather than a division. afterCondition tells
+// whether a closing parenthesis ends the condition of an if, while or for.
+func isRegexpStart(out []byte, afterCondition bool) bool {
+	out = bytes.TrimRight(out, " \n")
+	if len(out) == 0 {
+		return true
+	}
+	prev := out[len(out)-1]
+	if strings.IndexByte(REGEXP_PRECEDERS, prev) >= 0 {
+		return true
+	}
+	if prev == ')' {
+		return afterCondition
+	}
+	return isKeyword(lastWord(out), REGEXP_KEYWORDS)
+}
+
+// lastWord returns the identifier at the end of out, ignoring whitespace
+func lastWord(out []byte) string {
+	out = bytes.TrimRight(out, " \n")
+	start := len(out)
+	for start > 0 && isIdent(out[start-1]) {
+		start--
+	}
+	return string(out[start:])
+}
+
+// isKeyword returns true if word is one of keywords
+func isKeyword(word string, keywords []string) bool {
+	for _, keyword := range keywords {
+		if word == keyword {
+			return true
+		}
+	}
+	return false
+}
+
+// skipRegexp returns the index after the regular expression literal starting
+// at src[i], including its flags
+func skipRegexp(src string, i int) (int, error) {
+	class := false
+	for j := i + 1; j < len(src); j++ {
+		switch c := src[j]; {
+		case c == '\\':
+			j++
+		case c == '\n':
+			return 0, fmt.Errorf("Unterminated regular expression at offset %d", i)
+		case c == '[':
+			class = true
+		case c == ']':
+			class = false
+		case c == '/' && !class:
+			j++
+			for j < len(src) && isIdent(src[j]) {
+				j++
+			}
+			return j, nil
+		}
+	}
+	return 0, fmt.Errorf("Unterminated regular expression at offset %d", i)
+}
+
+// skipTemplate returns the index after the template literal starting at
+// src[i]. Substitutions are copied as they are.
+func skipTemplate(src string, i int) (int, error) {
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case '`':
+			return j + 1, nil
+		case '$':
+			if j+1 < len(src) && src[j+1] == '{' {
+				end, err := skipSubstitution(src, j+2)
+				if err != nil {
+					return 0, err
+				}
+				j = end - 1
+			}
+		}
+	}
+	return 0, fmt.Errorf("Unterminated template literal at offset %d", i)
+}
+
+// skipSubstitution returns the index after the } closing the template
+// substitution whose expression starts at src[i]
+func skipSubstitution(src string, i int) (int, error) {
+	depth := 0
+	for j := i; j < len(src); {
+		var err error
+		switch c := src[j]; c {
+		case '"', '\'':
+			j, err = skipString(src, j)
+		case '`':
+			j, err = skipTemplate(src, j)
+		case '{':
+			depth++
+			j++
+		case '}':
+			if depth == 0 {
+				return j + 1, nil
+			}
+			depth--
+			j++
+		default:
+			j++
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return 0, fmt.Errorf("Unterminated template substitution at offset %d", i)
+}
@@ -0,0 +1,71 @@
+// Package minify shrinks the CSS and JavaScript of inline styles and scripts.
+package minify
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Minifier shrinks source code without changing what it does
+type Minifier interface {
+	Minify(src string) (string, error)
+}
+
+// MinifierFunc adapts a function to the Minifier interface
+type MinifierFunc func(src string) (string, error)
+
+func (f MinifierFunc) Minify(src string) (string, error) {
+	return f(src)
+}
+
+var (
+	// CSS removes comments, whitespace and trailing semicolons from
+	// stylesheets
+	CSS Minifier = MinifierFunc(minifyCSS)
+	// JS removes comments and whitespace from scripts, keeping line breaks so
+	// that automatic semicolon insertion and source maps still work
+	JS Minifier = MinifierFunc(minifyJS)
+)
+
+// isKeptComment returns true for comments that survive minification: license
+// comments (/*! ... */) and Polymer's @polyfill directives
+func isKeptComment(comment string) bool {
+	return strings.HasPrefix(comment, "/*!") || strings.Contains(comment, "@polyfill")
+}
+
+// isIdent returns true for characters that may appear in identifiers,
+// numbers and keywords
+func isIdent(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 ||
+		'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+// skipString returns the index after the string starting with the quote at
+// src[i]. Strings may not span lines unless the line break is escaped.
+func skipString(src string, i int) (int, error) {
+	quote := src[i]
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case '\n':
+			return 0, fmt.Errorf("Unterminated string at offset %d", i)
+		case quote:
+			return j + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("Unterminated string at offset %d", i)
+}
+
+// skipComment returns the index after the block comment starting at src[i]
+func skipComment(src string, i int) (int, error) {
+	end := strings.Index(src[i+2:], "*/")
+	if end < 0 {
+		return 0, fmt.Errorf("Unterminated comment at offset %d", i)
+	}
+	return i + 2 + end + 2, nil
+}
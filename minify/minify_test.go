@@ -0,0 +1,56 @@
+package minify
+
+import "testing"
+
+func TestCSS(t *testing.T) {
+	cases := map[string]string{
+		"a  b ,\n c > d {\n  color : red;\n  margin: 0 auto;\n}\n": "a b,c>d{color:red;margin:0 auto}",
+		"/* gone */ p { content: ' a  b ' } /*! kept */":           "p{content:' a  b '}/*! kept */",
+		"div :hover { width: calc(1px + 2px) ; }":                  "div :hover{width:calc(1px + 2px)}",
+		"@media screen and (max-width: 10px) { a { b: c } }":       "@media screen and (max-width:10px){a{b:c}}",
+		"/* @polyfill .a */\n:host { display: block; }":            "/* @polyfill .a */ :host{display:block}",
+		"p { margin:0/**/auto; }":                                  "p{margin:0 auto}",
+	}
+	for src, expected := range cases {
+		out, err := CSS.Minify(src)
+		if err != nil {
+			t.Errorf("Could not minify %q: %v", src, err)
+		} else if out != expected {
+			t.Errorf("Expected %q, got %q", expected, out)
+		}
+	}
+
+	if _, err := CSS.Minify("a { content: 'x }"); err == nil {
+		t.Error("Expected an unterminated string to fail")
+	}
+}
+
+func TestJS(t *testing.T) {
+	cases := map[string]string{
+		"var a = 1 ;  // one\nvar b = a + +c;":                "var a=1;\nvar b=a+ +c;",
+		"/*! MIT */\nfunction f ( x ) {\n  return x / 2;\n}":  "/*! MIT */\nfunction f(x){\nreturn x/2;\n}",
+		"if (a) {\n  /* several\n lines */\n  b()\n}":         "if(a){\n\n\nb()\n}",
+		"x = 'a  b' + \"c // d\" + `e ${ f({ g: 'h' }) }  i`": "x='a  b'+\"c // d\"+`e ${ f({ g: 'h' }) }  i`",
+		"r = s.replace( /a b\\/[/ ]/g , '' )":                 "r=s.replace(/a b\\/[/ ]/g,'')",
+		"return /x /.test( y ) ;":                             "return/x /.test(y);",
+		"a = b / c / d":                                       "a=b/c/d",
+		"if(x) /a  b/.test(s)":                                "if(x)/a  b/.test(s)",
+		"a = (b + c) / d / e":                                 "a=(b+c)/d/e",
+		"n = 1 .toString( )":                                  "n=1 .toString()",
+		"go()\n//# sourceMappingURL=a.js.map\n":               "go()\n//# sourceMappingURL=a.js.map\n",
+	}
+	for src, expected := range cases {
+		out, err := JS.Minify(src)
+		if err != nil {
+			t.Errorf("Could not minify %q: %v", src, err)
+		} else if out != expected {
+			t.Errorf("Expected %q, got %q", expected, out)
+		}
+	}
+
+	for _, src := range []string{"a = '", "/* x", "a = `${b`", "x = (/a)"} {
+		if _, err := JS.Minify(src); err == nil {
+			t.Errorf("Expected %q to fail", src)
+		}
+	}
+}
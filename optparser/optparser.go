@@ -1,47 +1,76 @@
 package optparser
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/docopt/docopt.go"
+	"io"
 	"io/ioutil"
+	"log/slog"
+	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
+
+	"github.com/tbuckley/vulcanize/diagnostics"
+	"github.com/tbuckley/vulcanize/fetch"
+	"github.com/tbuckley/vulcanize/htmlutils"
+	"github.com/tbuckley/vulcanize/importer"
+	"github.com/tbuckley/vulcanize/sri"
+	"github.com/tbuckley/vulcanize/vulcanize"
 )
 
 var (
 	DEFAULT_FILENAME = "vulcanized.html"
 	ABS_URL          = regexp.MustCompilePOSIX("(^data:)|(^http[s]?:)|(^\\/)")
+	// LOCAL_ABS_URL replaces ABS_URL when remote files are fetched
+	LOCAL_ABS_URL = regexp.MustCompilePOSIX("(^data:)|(^\\/)")
 )
 
+// Options holds the parsed command-line options. The embedded
+// vulcanize.Options can be passed straight to vulcanize.Vulcanize.
 type Options struct {
-	Input     string
-	Output    string
-	OutputDir string
-	Excludes  Excludes
+	vulcanize.Options
 
-	CSP     bool
-	CSPFile string
-	Inline  bool
-	Strip   bool
+	// Graph is the file to write the dependency graph to, if any
+	Graph string
+	// Watch rebuilds the output whenever a file it was built from changes
+	Watch bool
 
-	Verbose bool
-}
+	// Inputs lists every entry point. When there is more than one, each is
+	// written to OutputDir along with a bundle of their shared imports.
+	Inputs []string
+	Shared string
+
+	// Serve runs the development server for the pages in Dir on Addr
+	Serve bool
+	Dir   string
+	Addr  string
 
-type Excludes struct {
-	Imports []*regexp.Regexp
-	Scripts []*regexp.Regexp
-	Styles  []*regexp.Regexp
+	// Quiet only logs warnings and errors, and leaves out warning diagnostics
+	Quiet bool
+
+	// Diagnostics is the format errors and warnings are printed in, either
+	// "text" or "json"
+	Diagnostics string
 }
 
 type Config struct {
 	Excludes ConfigExcludes `json:"excludes"`
+	Passes   ConfigPasses   `json:"passes"`
 }
 
 type ConfigExcludes struct {
-	Imports []string `json:"imports"`
-	Scripts []string `json:"scripts"`
-	Styles  []string `json:"styles"`
+	Imports   []string `json:"imports"`
+	Scripts   []string `json:"scripts"`
+	Styles    []string `json:"styles"`
+	Selectors []string `json:"selectors"`
+}
+
+type ConfigPasses struct {
+	// Disable lists the names of passes that should not run
+	Disable []string `json:"disable"`
 }
 
 func Parse() (*Options, error) {
@@ -51,86 +80,230 @@ func Parse() (*Options, error) {
 	// Parse the command-line args
 	arguments := parseArgs()
 
+	// Handle remote files
+	absURL := ABS_URL
+	offline := arguments["--offline"].(bool)
+	if arguments["--remote"].(bool) || offline {
+		absURL = LOCAL_ABS_URL
+		options.Remote = fetch.NewRemote()
+		options.Remote.Offline = offline
+		if hosts, ok := arguments["--remote-hosts"].(string); ok {
+			options.Remote.Hosts = strings.Split(hosts, ",")
+		}
+		if cacheDir, ok := arguments["--remote-cache"].(string); ok {
+			options.Remote.CacheDir = cacheDir
+		}
+	}
+
 	// Initial configuration
-	options.Excludes.Imports = []*regexp.Regexp{ABS_URL}
-	options.Excludes.Scripts = []*regexp.Regexp{ABS_URL}
-	options.Excludes.Styles = []*regexp.Regexp{ABS_URL}
+	options.Excludes.Imports = []*regexp.Regexp{absURL}
+	options.Excludes.Scripts = []*regexp.Regexp{absURL}
+	options.Excludes.Styles = []*regexp.Regexp{absURL}
 
 	// Set initial options
-	options.Input = arguments["<input>"].(string)
-	options.Verbose = arguments["--verbose"].(bool)
+	options.Serve = arguments["serve"].(bool)
+	if options.Serve {
+		options.Dir = arguments["<dir>"].(string)
+		options.Addr = arguments["--addr"].(string)
+	} else {
+		options.Inputs = arguments["<input>"].([]string)
+		options.Input = options.Inputs[0]
+	}
+	options.Diagnostics = arguments["--diagnostics"].(string)
+	if options.Diagnostics != "text" && options.Diagnostics != "json" {
+		return nil, diagnostics.Errorf(diagnostics.CODE_CONFIG, "Unknown diagnostics format %q, use text or json", options.Diagnostics)
+	}
+	options.Format = arguments["--format"].(string)
+	switch options.Format {
+	case vulcanize.FORMAT_PRETTY, vulcanize.FORMAT_COMPACT, vulcanize.FORMAT_PRESERVE:
+	default:
+		return nil, diagnostics.Errorf(diagnostics.CODE_CONFIG, "Unknown format %q, use pretty, compact or preserve", options.Format)
+	}
+	options.Quiet = arguments["--quiet"].(bool)
+	level := slog.LevelInfo
+	if arguments["--verbose"].(bool) {
+		level = slog.LevelDebug
+	} else if options.Quiet {
+		level = slog.LevelWarn
+	}
+	options.Logger = NewLogger(os.Stderr, level)
 	options.Strip = arguments["--strip"].(bool)
 	options.Inline = arguments["--inline"].(bool)
+	options.AllowCycles = arguments["--allow-cycles"].(bool)
+	options.KeepGoing = arguments["--keep-going"].(bool)
+	options.Watch = arguments["--watch"].(bool)
+	if cacheDir, ok := arguments["--parse-cache"].(string); ok {
+		options.Cache = importer.NewCache()
+		options.Cache.Dir = cacheDir
+	} else if options.Watch {
+		options.Cache = importer.NewCache()
+	}
 
-	// Handle output
-	outputFile, ok := arguments["--output"].(string)
-	if ok {
-		options.Output = outputFile
-	} else {
-		options.Output = filepath.Join(filepath.Dir(options.Input), DEFAULT_FILENAME)
+	// Handle output. Pages served by the dev server are built in memory.
+	if len(options.Inputs) > 1 {
+		outputDir, ok := arguments["--out-dir"].(string)
+		if !ok {
+			outputDir = filepath.Join(filepath.Dir(options.Input), "vulcanized")
+		}
+		options.OutputDir = outputDir
+		options.Shared = arguments["--shared"].(string)
+		if arguments["--output"] != nil || arguments["--graph"] != nil {
+			return nil, fmt.Errorf("--output and --graph need a single input, use --out-dir")
+		}
+	} else if !options.Serve {
+		outputFile, ok := arguments["--output"].(string)
+		if ok {
+			options.Output = outputFile
+		} else {
+			options.Output = filepath.Join(filepath.Dir(options.Input), DEFAULT_FILENAME)
+		}
+		options.OutputDir = filepath.Dir(options.Output)
+	}
+
+	if graphFile, ok := arguments["--graph"].(string); ok {
+		options.Graph = graphFile
 	}
-	options.OutputDir = filepath.Dir(options.Output)
 
 	// Handle CSP
 	options.CSP = arguments["--csp"].(bool)
-	if options.CSP {
+	options.CSPStyles = arguments["--csp-styles"].(bool)
+	if options.CSPStyles && options.Output != "" {
+		options.CSSFile = strings.TrimSuffix(options.Output, filepath.Ext(options.Output)) + ".css"
+	}
+	options.CSPHashes = arguments["--csp-hashes"].(bool)
+	options.CSPMeta = arguments["--csp-meta"].(bool)
+	if options.CSPMeta && !options.CSPHashes {
+		return nil, diagnostics.Errorf(diagnostics.CODE_CONFIG, "--csp-meta needs --csp-hashes")
+	}
+	options.HashNames = arguments["--hash-names"].(bool)
+	if algorithm, ok := arguments["--sri"].(string); ok {
+		if !sri.IsAlgorithm(algorithm) {
+			return nil, diagnostics.Errorf(diagnostics.CODE_CONFIG, "Unknown --sri algorithm %q, use %s", algorithm, strings.Join(sri.ALGORITHMS, ", "))
+		}
+		options.SRI = algorithm
+	}
+	if options.CSP && options.Output != "" {
 		dir, htmlFile := filepath.Split(options.Output)
-		jsFile := htmlFile[:len(htmlFile)-len(".html")] + ".js"
+		jsFile := strings.TrimSuffix(htmlFile, filepath.Ext(htmlFile)) + ".js"
 		options.CSPFile = filepath.Join(dir, jsFile)
 	}
+	if options.CSPHashes && options.Output != "" {
+		options.CSPPolicyFile = strings.TrimSuffix(options.Output, filepath.Ext(options.Output)) + vulcanize.POLICY_EXT
+	}
 
 	// Try to parse config file
-	if arguments["--config"] != nil {
-		configData, err := ioutil.ReadFile(arguments["--config"].(string))
+	if configFile, ok := arguments["--config"].(string); ok {
+		configData, err := ioutil.ReadFile(configFile)
 		if err != nil {
-			return nil, fmt.Errorf("Config file not found!")
+			d := diagnostics.ReadError(configFile, err)
+			d.Code = diagnostics.CODE_CONFIG
+			return nil, d
 		}
 		err = json.Unmarshal([]byte(configData), &config)
 		if err != nil {
-			return nil, fmt.Errorf("Malformed config JSON!")
+			return nil, configError(configFile, configData, err)
 		}
-	}
 
-	// Read excludes from config file
-	for _, restr := range config.Excludes.Imports {
-		re, err := regexp.CompilePOSIX(restr)
-		if err != nil {
-			return nil, fmt.Errorf("Malformed import exclude config")
+		// Read excludes from config file
+		excludes := []struct {
+			name     string
+			patterns []string
+			res      *[]*regexp.Regexp
+		}{
+			{"imports", config.Excludes.Imports, &options.Excludes.Imports},
+			{"scripts", config.Excludes.Scripts, &options.Excludes.Scripts},
+			{"styles", config.Excludes.Styles, &options.Excludes.Styles},
 		}
-		options.Excludes.Imports = append(options.Excludes.Imports, re)
-	}
-	for _, restr := range config.Excludes.Scripts {
-		re, err := regexp.CompilePOSIX(restr)
-		if err != nil {
-			return nil, fmt.Errorf("Malformed import exclude config")
+		for _, exclude := range excludes {
+			for _, restr := range exclude.patterns {
+				re, err := regexp.CompilePOSIX(restr)
+				if err != nil {
+					return nil, diagnostics.Errorf(diagnostics.CODE_CONFIG, "Malformed %s exclude %q: %v", exclude.name, restr, err).InFile(configFile).Wrap(err)
+				}
+				*exclude.res = append(*exclude.res, re)
+			}
 		}
-		options.Excludes.Scripts = append(options.Excludes.Scripts, re)
-	}
-	for _, restr := range config.Excludes.Styles {
-		re, err := regexp.CompilePOSIX(restr)
-		if err != nil {
-			return nil, fmt.Errorf("Malformed import exclude config")
+		for _, selector := range config.Excludes.Selectors {
+			pred, err := htmlutils.Compile(selector)
+			if err != nil {
+				return nil, diagnostics.Errorf(diagnostics.CODE_CONFIG, "Malformed selector exclude: %v", err).InFile(configFile).Wrap(err)
+			}
+			options.Excludes.Selectors = append(options.Excludes.Selectors, pred)
 		}
-		options.Excludes.Styles = append(options.Excludes.Styles, re)
 	}
 
+	options.DisabledPasses = config.Passes.Disable
+
 	return options, nil
 }
 
+// NewLogger creates a logger writing messages of at least level to w, without
+// timestamps
+func NewLogger(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	}))
+}
+
+// configError describes a config file that is not valid JSON, locating the
+// problem when the decoder reports where it is
+func configError(configFile string, data []byte, err error) error {
+	d := diagnostics.Errorf(diagnostics.CODE_CONFIG, "Malformed config JSON: %v", err).InFile(configFile).Wrap(err)
+	var offset int64 = -1
+	if syntaxErr, ok := err.(*json.SyntaxError); ok {
+		offset = syntaxErr.Offset
+	} else if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+		offset = typeErr.Offset
+	}
+	if offset >= 0 && offset <= int64(len(data)) {
+		before := data[:offset]
+		d.Line = bytes.Count(before, []byte("\n")) + 1
+		d.Col = len(before) - bytes.LastIndexByte(before, '\n')
+	}
+	return d
+}
+
 func parseArgs() map[string]interface{} {
 	usage := `Go Vulcanize.
 
 Usage:
-  vulcanize [options] <input>
+  vulcanize [options] <input>...
+  vulcanize serve [options] <dir>
 
 Options:
   -h, --help                  Show this screen.
-  -v, --verbose               Verbose mode.
+  -v, --verbose               Log debug messages.
+  -q, --quiet                 Only log warnings and errors, and leave out warning diagnostics.
   -o <file>, --output <file>  Output file name (defaults to vulcanized.html).
   --config <file>             Read a given config file.
-  --strip                     Remove comments and empty text nodes.
-  --csp                       Extract inline scripts to a separate file (uses <output file name>.js).
-  --inline                    The opposite of CSP mode, inline all assets (script and css) into the document.`
+  --strip                     Remove comments and unrendered whitespace, and minify styles and scripts.
+  --format <format>           Lay the output out as pretty, compact or preserve [default: preserve].
+  --csp                       Extract inline scripts to a separate file (uses <output file name>.js, with a .js.map source map, and numbered files when needed to keep the script order).
+  --csp-styles                Move the <style> blocks of the main document to a separate file (uses <output file name>.css).
+  --csp-hashes                Keep inline scripts and styles, and write a Content-Security-Policy allowing them by hash (uses <output file name>.csp).
+  --csp-meta                  With --csp-hashes, also add the policy to the document as a <meta http-equiv>.
+  --hash-names                Name the CSP script and shared bundle after a hash of their content, listed in manifest.json.
+  --sri <algorithm>           Add integrity attributes hashed with sha256, sha384 or sha512 to local scripts and stylesheets, and check existing ones.
+  --inline                    The opposite of CSP mode, inline all assets (script and css) into the document.
+  --allow-cycles              Warn about import cycles instead of failing.
+  -k, --keep-going            Report every file that cannot be read instead of stopping at the first.
+  --graph <file>              Write the dependency graph to a file (JSON for .json files, otherwise Graphviz DOT).
+  --remote                    Fetch and inline http(s) imports, scripts and stylesheets.
+  --remote-hosts <hosts>      Comma-separated hosts that may be fetched from (defaults to any host).
+  --remote-cache <dir>        Cache fetched files in a directory.
+  --offline                   Like --remote, but only serve files from the cache.
+  -w, --watch                 Rebuild whenever one of the input files changes.
+  --parse-cache <dir>         Keep parsed files in a directory to speed up later runs.
+  --addr <addr>               Address for serve to listen on [default: :8080].
+  --out-dir <dir>             Output directory when there are several inputs (defaults to vulcanized/).
+  --shared <file>             Name of the bundle of imports shared by several inputs [default: shared.html].
+  --diagnostics <format>      Print errors and warnings as text or json [default: text].`
 
 	arguments, _ := docopt.Parse(usage, nil, true, "Go Vulcanize 0.0.1", false)
 	return arguments
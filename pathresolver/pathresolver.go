@@ -1,9 +1,12 @@
 package pathresolver
 
 import (
+	"code.google.com/p/go.net/html"
 	"github.com/tbuckley/vulcanize/htmlutils"
+	"net/url"
 	"path/filepath"
 	"regexp"
+	"strings"
 )
 
 var (
@@ -13,37 +16,69 @@ var (
 	URL_TEMPLATE = regexp.MustCompile("{{.*}}")
 )
 
+// ResolvePaths rewrites the relative URLs in a fragment loaded from inputPath
+// to be relative to outputPath
 func ResolvePaths(input *htmlutils.Fragment, inputPath string, outputPath string) {
-	resolveAttributePaths(input, inputPath, outputPath)
-	resolveCSSPaths(input, inputPath, outputPath)
+	rewrite := func(rel string) string {
+		return RewriteRelPath(inputPath, outputPath, rel)
+	}
+	resolveAttributePaths(input, rewrite)
+	resolveCSSPaths(input, rewrite)
 	addAssetpathAttribute(input, inputPath, outputPath)
 }
 
+// ResolveRemotePaths rewrites the relative URLs in a fragment fetched from
+// base to be absolute URLs
+func ResolveRemotePaths(input *htmlutils.Fragment, base string) {
+	rewrite := func(rel string) string {
+		if isFragment(rel) {
+			return rel
+		}
+		return ResolveURL(base, rel)
+	}
+	resolveAttributePaths(input, rewrite)
+	resolveCSSPaths(input, rewrite)
+	setAssetpath(input, ResolveURL(base, "."))
+}
+
 // resolveAttributePaths rewrites any relative URLs found in node attributes
-// (eg. href, src, action, style)
-func resolveAttributePaths(input *htmlutils.Fragment, inputPath string, outputPath string) {
+// (eg. href, src, action, style), including the xlink:href of SVG elements
+func resolveAttributePaths(input *htmlutils.Fragment, rewrite func(string) string) {
 	URL_ATTR := []string{"href", "src", "action", "style"}
 	matches := input.Search(htmlutils.HasAnyAttrP(URL_ATTR...))
 	for _, match := range matches {
-		for _, attr := range URL_ATTR {
-			if val, ok := htmlutils.Attr(match, attr); ok {
-				if URL_TEMPLATE.FindAllStringIndex(val, -1) == nil {
-					if attr == "style" {
-						htmlutils.SetAttr(match, attr, RewriteURL(inputPath, outputPath, val))
-					} else {
-						htmlutils.SetAttr(match, attr, RewriteRelPath(inputPath, outputPath, val))
-					}
-				}
+		for i, attr := range match.Attr {
+			if !isURLAttr(attr, URL_ATTR) || URL_TEMPLATE.FindAllStringIndex(attr.Val, -1) != nil {
+				continue
+			}
+			if attr.Key == "style" {
+				match.Attr[i].Val = RewriteURLFunc(attr.Val, rewrite)
+			} else {
+				match.Attr[i].Val = rewrite(attr.Val)
 			}
 		}
 	}
 }
 
+// isURLAttr returns true if attr is one of names, either without a namespace
+// or in the xlink namespace
+func isURLAttr(attr html.Attribute, names []string) bool {
+	if attr.Namespace != "" && attr.Namespace != "xlink" {
+		return false
+	}
+	for _, name := range names {
+		if attr.Key == name {
+			return true
+		}
+	}
+	return false
+}
+
 // resolveCSSPaths rewrites any relative URLs found in CSS blocks
-func resolveCSSPaths(input *htmlutils.Fragment, inputPath string, outputPath string) {
+func resolveCSSPaths(input *htmlutils.Fragment, rewrite func(string) string) {
 	matches := input.Search(htmlutils.IsStyleBlock)
 	for _, match := range matches {
-		text := RewriteURL(inputPath, outputPath, htmlutils.TextContent(match))
+		text := RewriteURLFunc(htmlutils.TextContent(match), rewrite)
 		htmlutils.SetTextContent(match, text)
 	}
 }
@@ -55,6 +90,12 @@ func addAssetpathAttribute(input *htmlutils.Fragment, inputPath string, outputPa
 	if assetPath != "" {
 		assetPath += "/"
 	}
+	setAssetpath(input, assetPath)
+}
+
+// setAssetpath sets the assetpath attribute on any polymer-element nodes that
+// are missing it
+func setAssetpath(input *htmlutils.Fragment, assetPath string) {
 	matches := input.Search(htmlutils.IsPolymerElementMissingAssetpath)
 	for _, match := range matches {
 		htmlutils.SetAttr(match, "assetpath", assetPath)
@@ -63,7 +104,7 @@ func addAssetpathAttribute(input *htmlutils.Fragment, inputPath string, outputPa
 
 // RewriteRelPath rewrites a path relative to inputPath to be relative to outputPath
 func RewriteRelPath(inputPath string, outputPath string, rel string) string {
-	if isAbsoluteURL(rel) {
+	if isAbsoluteURL(rel) || isFragment(rel) {
 		return rel
 	}
 	abs := filepath.Join(inputPath, rel)
@@ -74,19 +115,46 @@ func RewriteRelPath(inputPath string, outputPath string, rel string) string {
 // RewriteURL converts all instances of `url('<RELPATH>')` in a CSS string to urls
 // relative to the outputPath
 func RewriteURL(inputPath string, outputPath string, cssText string) string {
+	return RewriteURLFunc(cssText, func(rel string) string {
+		return RewriteRelPath(inputPath, outputPath, rel)
+	})
+}
+
+// RewriteURLFunc replaces the path in all instances of `url('<PATH>')` in a
+// CSS string with the result of rewrite
+func RewriteURLFunc(cssText string, rewrite func(string) string) string {
 	return URL.ReplaceAllStringFunc(cssText, func(match string) string {
 		path := stripQuotes(match)
 		path = path[4 : len(path)-1]
-		path = RewriteRelPath(inputPath, outputPath, path)
+		path = rewrite(path)
 		return "url(" + path + ")"
 	})
 }
 
+// ResolveURL resolves ref against the absolute URL base
+func ResolveURL(base string, ref string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return b.ResolveReference(r).String()
+}
+
 // isAbsoluteURL returns true if url is absolute
 func isAbsoluteURL(url string) bool {
 	return ABS_URL.MatchString(url)
 }
 
+// isFragment returns true if ref points into the document it appears in, as
+// SVG references like #icon and url(#gradient) do
+func isFragment(ref string) bool {
+	return strings.HasPrefix(ref, "#")
+}
+
 // stripQuotes removes all single and double quotes from a string
 func stripQuotes(str string) string {
 	return QUOTES.ReplaceAllString(str, "")
@@ -8,20 +8,26 @@ import (
 	"testing"
 )
 
-func TestPathResolver_resolveAttributePaths(t *testing.T) {
-	p := new(PathResolver)
+// resolveHelper parses input, applies resolve with paths rewritten from
+// inputPath to outputPath, and renders the element with the given id
+func resolveHelper(input string, inputPath string, outputPath string, id string,
+	resolve func(*htmlutils.Fragment, func(string) string)) string {
+	document, _ := htmlutils.Parse(strings.NewReader(input), "test.html", nil)
+	resolve(document, func(rel string) string {
+		return RewriteRelPath(inputPath, outputPath, rel)
+	})
+	buf := new(bytes.Buffer)
+	target := htmlutils.GetElementByID(document.FirstNode, id)
+	if target != nil {
+		html.Render(buf, target)
+		return buf.String()
+	}
+	return ""
+}
 
+func TestPathResolver_resolveAttributePaths(t *testing.T) {
 	helper := func(input string, inputPath string, outputPath string, id string) string {
-		r := strings.NewReader(input)
-		document, _ := html.Parse(r)
-		p.resolveAttributePaths(document, inputPath, outputPath)
-		buf := new(bytes.Buffer)
-		target := htmlutils.GetElementByID(document, id)
-		if target != nil {
-			html.Render(buf, target)
-			return buf.String()
-		}
-		return ""
+		return resolveHelper(input, inputPath, outputPath, id, resolveAttributePaths)
 	}
 
 	output := helper("<a id=\"target\" href=\"qux/page.html\"></a>", "/foo/bar", "/foo/baz", "target")
@@ -41,25 +47,34 @@ func TestPathResolver_resolveAttributePaths(t *testing.T) {
 	if output != expected {
 		t.Errorf("Expected %v, got %v", expected, output)
 	}
+
+	output = helper("<a href=\"#top\" id=\"target\"></a>", "/foo/bar", "/foo/baz", "target")
+	expected = "<a href=\"#top\" id=\"target\"></a>"
+	if output != expected {
+		t.Errorf("Expected %v, got %v", expected, output)
+	}
 }
 
-func TestPathResolver_resolveCSSPaths(t *testing.T) {
-	p := new(PathResolver)
+func TestPathResolver_resolveAttributePathsSVG(t *testing.T) {
+	helper := func(input string, id string) string {
+		return resolveHelper(input, "/foo/bar", "/foo/baz", id, resolveAttributePaths)
+	}
 
-	helper := func(input string, inputPath string, outputPath string, id string) string {
-		r := strings.NewReader(input)
-		document, _ := html.Parse(r)
-		p.resolveCSSPaths(document, inputPath, outputPath)
-		buf := new(bytes.Buffer)
-		target := htmlutils.GetElementByID(document, id)
-		if target != nil {
-			html.Render(buf, target)
-			return buf.String()
-		}
-		return ""
+	output := helper(`<svg><use id="target" xlink:href="icons.svg#menu" href="icons.svg#close"/></svg>`, "target")
+	expected := `<use id="target" xlink:href="../bar/icons.svg#menu" href="../bar/icons.svg#close"></use>`
+	if output != expected {
+		t.Errorf("Expected %v, got %v", expected, output)
+	}
+
+	output = helper(`<svg><use id="target" xlink:href="#menu" style="fill: url(#gradient)"/></svg>`, "target")
+	expected = `<use id="target" xlink:href="#menu" style="fill: url(#gradient)"></use>`
+	if output != expected {
+		t.Errorf("Expected %v, got %v", expected, output)
 	}
+}
 
-	output := helper("<style id=\"target\">body {background-image: url('qux/page.html');}</style>", "/foo/bar", "/foo/baz", "target")
+func TestPathResolver_resolveCSSPaths(t *testing.T) {
+	output := resolveHelper("<style id=\"target\">body {background-image: url('qux/page.html');}</style>", "/foo/bar", "/foo/baz", "target", resolveCSSPaths)
 	expected := "<style id=\"target\">body {background-image: url(../bar/qux/page.html);}</style>"
 	if output != expected {
 		t.Errorf("Expected %v, got %v", expected, output)
@@ -67,19 +82,10 @@ func TestPathResolver_resolveCSSPaths(t *testing.T) {
 }
 
 func TestPathResolver_addAssetpathAttribute(t *testing.T) {
-	p := new(PathResolver)
-
 	helper := func(input string, inputPath string, outputPath string, id string) string {
-		r := strings.NewReader(input)
-		document, _ := html.Parse(r)
-		p.addAssetpathAttribute(document, inputPath, outputPath)
-		buf := new(bytes.Buffer)
-		target := htmlutils.GetElementByID(document, id)
-		if target != nil {
-			html.Render(buf, target)
-			return buf.String()
-		}
-		return ""
+		return resolveHelper(input, inputPath, outputPath, id, func(doc *htmlutils.Fragment, rewrite func(string) string) {
+			addAssetpathAttribute(doc, inputPath, outputPath)
+		})
 	}
 
 	output := helper("<polymer-element id=\"target\"></polymer-element>", "/foo/bar", "/foo/baz", "target")
@@ -90,17 +96,14 @@ func TestPathResolver_addAssetpathAttribute(t *testing.T) {
 }
 
 func TestPathResolver_rewriteRelPath(t *testing.T) {
-	p := new(PathResolver)
-
-	result := p.rewriteRelPath("/foo/bar", "/foo/baz", "qux/page.html")
+	result := RewriteRelPath("/foo/bar", "/foo/baz", "qux/page.html")
 	if result != "../bar/qux/page.html" {
 		t.Errorf("Expected %v, got %v", "../bar/qux/page.html", result)
 	}
 }
 
 func TestPathResolver_rewriteURL(t *testing.T) {
-	p := new(PathResolver)
-	cssText := p.rewriteURL("/foo/bar", "/foo/baz", "background-image: url('backgrounds/bkg.png')")
+	cssText := RewriteURL("/foo/bar", "/foo/baz", "background-image: url('backgrounds/bkg.png')")
 	if cssText != "background-image: url(../bar/backgrounds/bkg.png)" {
 		t.Errorf("Expected rewritten url, got %v", cssText)
 	}
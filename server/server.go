@@ -0,0 +1,139 @@
+// Package server serves vulcanized pages over HTTP, building them from the
+// source files on every request.
+package server
+
+import (
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/tbuckley/vulcanize/importer"
+	"github.com/tbuckley/vulcanize/vulcanize"
+)
+
+var (
+	// CSP_SUFFIX replaces .html in a page's URL to give the URL of the
+	// script extracted from it in CSP mode
+	CSP_SUFFIX = ".csp.js"
+	// CSS_SUFFIX does the same for the styles extracted with CSPStyles
+	CSS_SUFFIX = ".csp.css"
+	// CSP_SCRIPT matches the URLs of the scripts extracted from a page and
+	// their source maps, capturing the page's URL without .html
+	CSP_SCRIPT = regexp.MustCompile(`^(.+)\.csp(?:-\d+)?\.js(?:\.map)?$`)
+)
+
+// Handler serves every .html page in FS vulcanized, and every other file as
+// is. Pages are built with Options, with Input, OutputDir and CSPFile set for
+// the requested page.
+type Handler struct {
+	FS      fs.FS
+	Options vulcanize.Options
+
+	static http.Handler
+}
+
+// New creates a handler serving the files in fsys. Build errors and warnings
+// are logged to options.Logger, or slog.Default() when it is nil.
+func New(fsys fs.FS, options vulcanize.Options) *Handler {
+	if options.Cache == nil {
+		options.Cache = importer.NewCache()
+	}
+	if options.Logger == nil {
+		options.Logger = slog.Default()
+	}
+	options.FS = fsys
+	return &Handler{
+		FS:      fsys,
+		Options: options,
+		static:  http.FileServer(http.FS(fsys)),
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	if strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	}
+
+	switch {
+	case strings.HasSuffix(name, ".html") && h.exists(name):
+		h.servePage(w, name, name)
+	case h.Options.CSP && CSP_SCRIPT.MatchString(name):
+		page := CSP_SCRIPT.FindStringSubmatch(name)[1] + ".html"
+		if h.exists(page) {
+			h.servePage(w, page, name)
+			return
+		}
+		h.static.ServeHTTP(w, r)
+	case h.Options.CSPStyles && strings.HasSuffix(name, CSS_SUFFIX):
+		page := strings.TrimSuffix(name, CSS_SUFFIX) + ".html"
+		if h.exists(page) {
+			h.servePage(w, page, name)
+			return
+		}
+		h.static.ServeHTTP(w, r)
+	default:
+		h.static.ServeHTTP(w, r)
+	}
+}
+
+// servePage vulcanizes page and writes the output named name: the document,
+// one of its extracted scripts or their source maps, or its extracted styles
+func (h *Handler) servePage(w http.ResponseWriter, page string, name string) {
+	options := h.Options
+	options.Input = page
+	options.OutputDir = path.Dir(page)
+	options.CSPFile = strings.TrimSuffix(page, ".html") + CSP_SUFFIX
+	options.CSSFile = strings.TrimSuffix(page, ".html") + CSS_SUFFIX
+	// Scripts are served under the name of their page
+	options.HashNames = false
+
+	result, err := vulcanize.Vulcanize(options)
+	if err != nil {
+		h.Options.Logger.Error("Could not build page", "page", page, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, warning := range result.Warnings {
+		h.Options.Logger.Warn("Built page with warnings", "page", page, "warning", warning)
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if result.CSPPolicy != "" && name == page {
+		w.Header().Set("Content-Security-Policy", result.CSPPolicy)
+	}
+	var script *vulcanize.ScriptFile
+	for _, file := range result.Scripts {
+		if path.Base(file.Name) == path.Base(strings.TrimSuffix(name, ".map")) {
+			script = file
+		}
+	}
+	switch {
+	case name == page:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(result.HTML))
+	case strings.HasSuffix(name, ".css") && result.CSSFile != "":
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		w.Write([]byte(result.Styles))
+	case script == nil, strings.HasSuffix(name, ".map") && script.SourceMap == "":
+		http.Error(w, name+" not found", http.StatusNotFound)
+	case strings.HasSuffix(name, ".map"):
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(script.SourceMap))
+	default:
+		w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+		w.Write([]byte(script.Content))
+	}
+}
+
+// exists returns true if name is a regular file in FS
+func (h *Handler) exists(name string) bool {
+	info, err := fs.Stat(h.FS, name)
+	return err == nil && !info.IsDir()
+}
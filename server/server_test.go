@@ -0,0 +1,80 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/tbuckley/vulcanize/vulcanize"
+)
+
+var testFS = fstest.MapFS{
+	"app/index.html": &fstest.MapFile{Data: []byte(`<!doctype html><html><head>
+<link rel="import" href="foo-a.html">
+</head><body><foo-a></foo-a></body></html>`)},
+	"app/foo-a.html": &fstest.MapFile{Data: []byte(`<polymer-element name="foo-a">
+<script>Polymer({});</script>
+</polymer-element>`)},
+	"app/logo.png": &fstest.MapFile{Data: []byte("PNG")},
+}
+
+func get(t *testing.T, h http.Handler, url string) (int, string) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	body, _ := ioutil.ReadAll(w.Body)
+	return w.Code, string(body)
+}
+
+func TestHandler(t *testing.T) {
+	h := New(testFS, vulcanize.Options{CSP: true})
+
+	for _, url := range []string{"/app/index.html", "/app/"} {
+		code, body := get(t, h, url)
+		if code != http.StatusOK || !strings.Contains(body, `<polymer-element name="foo-a" assetpath="./">`) {
+			t.Errorf("Expected vulcanized page for %v, got %v %v", url, code, body)
+		}
+		if !strings.Contains(body, `<script src="index.csp.js"></script>`) {
+			t.Errorf("Expected CSP script reference for %v, got %v", url, body)
+		}
+	}
+
+	code, body := get(t, h, "/app/index.csp.js")
+	if code != http.StatusOK || !strings.Contains(body, "Polymer('foo-a',{});") {
+		t.Errorf("Expected extracted script, got %v %v", code, body)
+	}
+
+	code, body = get(t, h, "/app/logo.png")
+	if code != http.StatusOK || body != "PNG" {
+		t.Errorf("Expected static file, got %v %v", code, body)
+	}
+
+	code, _ = get(t, h, "/app/index.csp-2.js")
+	if code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a script the page does not have, got %v", code)
+	}
+
+	code, _ = get(t, h, "/app/missing.csp.js")
+	if code != http.StatusNotFound {
+		t.Errorf("Expected 404 for script of a missing page, got %v", code)
+	}
+}
+
+func TestHandler_Logger(t *testing.T) {
+	fsys := fstest.MapFS{
+		"broken.html": &fstest.MapFile{Data: []byte(`<link rel="import" href="missing.html">`)},
+	}
+	buf := new(bytes.Buffer)
+	h := New(fsys, vulcanize.Options{Logger: slog.New(slog.NewTextHandler(buf, nil))})
+
+	if code, _ := get(t, h, "/broken.html"); code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 for a broken page, got %v", code)
+	}
+	if !strings.Contains(buf.String(), "level=ERROR") || !strings.Contains(buf.String(), "page=broken.html") {
+		t.Errorf("Expected the error to be logged, got %v", buf.String())
+	}
+}
@@ -0,0 +1,101 @@
+// Package sourcemap builds version 3 source maps.
+package sourcemap
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const BASE64 = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// Map is the JSON form of a source map
+type Map struct {
+	Version  int      `json:"version"`
+	File     string   `json:"file,omitempty"`
+	Sources  []string `json:"sources"`
+	Names    []string `json:"names"`
+	Mappings string   `json:"mappings"`
+}
+
+// JSON returns the encoded map
+func (m *Map) JSON() string {
+	data, _ := json.Marshal(m)
+	return string(data)
+}
+
+// segment maps a column of the generated file to a position in a source
+type segment struct {
+	col, source, srcLine, srcCol int
+}
+
+// Generator accumulates mappings from a generated file to its sources.
+// Lines and columns are 0-based.
+type Generator struct {
+	sources []string
+	index   map[string]int
+	lines   [][]segment
+}
+
+// NewGenerator creates a generator with no mappings
+func NewGenerator() *Generator {
+	return &Generator{index: make(map[string]int)}
+}
+
+// AddMapping maps line and col of the generated file to srcLine and srcCol of
+// source. Mappings on a line must be added in column order.
+func (g *Generator) AddMapping(line, col int, source string, srcLine, srcCol int) {
+	i, ok := g.index[source]
+	if !ok {
+		i = len(g.sources)
+		g.sources = append(g.sources, source)
+		g.index[source] = i
+	}
+	for len(g.lines) <= line {
+		g.lines = append(g.lines, nil)
+	}
+	g.lines[line] = append(g.lines[line], segment{col, i, srcLine, srcCol})
+}
+
+// Map returns the source map for the generated file named file
+func (g *Generator) Map(file string) *Map {
+	var prev segment
+	lines := make([]string, 0, len(g.lines))
+	for _, segs := range g.lines {
+		encoded := make([]string, 0, len(segs))
+		prev.col = 0
+		for _, s := range segs {
+			encoded = append(encoded, vlq(s.col-prev.col)+vlq(s.source-prev.source)+vlq(s.srcLine-prev.srcLine)+vlq(s.srcCol-prev.srcCol))
+			prev = s
+		}
+		lines = append(lines, strings.Join(encoded, ","))
+	}
+	sources := make([]string, len(g.sources))
+	copy(sources, g.sources)
+	return &Map{
+		Version:  3,
+		File:     file,
+		Sources:  sources,
+		Names:    []string{},
+		Mappings: strings.Join(lines, ";"),
+	}
+}
+
+// vlq encodes n as a base64 variable-length quantity
+func vlq(n int) string {
+	v := n << 1
+	if n < 0 {
+		v = (-n << 1) | 1
+	}
+	encoded := ""
+	for {
+		digit := v & 31
+		v >>= 5
+		if v > 0 {
+			digit |= 32
+		}
+		encoded += string(BASE64[digit])
+		if v == 0 {
+			return encoded
+		}
+	}
+}
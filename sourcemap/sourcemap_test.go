@@ -0,0 +1,26 @@
+package sourcemap
+
+import "testing"
+
+func TestVLQ(t *testing.T) {
+	cases := map[int]string{0: "A", 1: "C", -1: "D", 15: "e", 16: "gB", -17: "jB", 1000: "w+B"}
+	for n, expected := range cases {
+		if encoded := vlq(n); encoded != expected {
+			t.Errorf("Expected vlq(%v) = %v, got %v", n, expected, encoded)
+		}
+	}
+}
+
+func TestGenerator_Map(t *testing.T) {
+	g := NewGenerator()
+	g.AddMapping(0, 0, "a.html", 9, 12)
+	g.AddMapping(1, 0, "a.html", 10, 0)
+	g.AddMapping(3, 0, "b.js", 0, 0)
+	g.AddMapping(3, 5, "b.js", 0, 5)
+
+	m := g.Map("out.js")
+	expected := `{"version":3,"file":"out.js","sources":["a.html","b.js"],"names":[],"mappings":"AASY;AACZ;;ACVA,KAAK"}`
+	if m.JSON() != expected {
+		t.Errorf("Expected %v, got %v", expected, m.JSON())
+	}
+}
@@ -0,0 +1,82 @@
+// Package sri computes and checks Subresource Integrity hashes, as found in
+// the integrity attribute of scripts and stylesheets.
+package sri
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// ALGORITHMS holds the supported hash functions, weakest first
+var ALGORITHMS = []string{"sha256", "sha384", "sha512"}
+
+var hashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// IsAlgorithm returns true if algorithm is one of ALGORITHMS
+func IsAlgorithm(algorithm string) bool {
+	_, ok := hashes[algorithm]
+	return ok
+}
+
+// Hash returns the integrity metadata of content, as in sha384-<base64>
+func Hash(algorithm string, content []byte) (string, error) {
+	newHash, ok := hashes[algorithm]
+	if !ok {
+		return "", fmt.Errorf("Unknown integrity algorithm %q, use %s", algorithm, strings.Join(ALGORITHMS, ", "))
+	}
+	h := newHash()
+	h.Write(content)
+	return algorithm + "-" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// Verify checks content against an integrity attribute. As in browsers, only
+// the hashes using the strongest algorithm listed are considered, one of
+// which must match, and attributes without a supported hash always pass.
+func Verify(integrity string, content []byte) error {
+	strongest := -1
+	expected := make([]string, 0)
+	for _, metadata := range strings.Fields(integrity) {
+		// Options such as sha384-...?foo are ignored
+		metadata = strings.SplitN(metadata, "?", 2)[0]
+		parts := strings.SplitN(metadata, "-", 2)
+		if len(parts) != 2 || !IsAlgorithm(parts[0]) {
+			continue
+		}
+		switch strength := indexOf(ALGORITHMS, parts[0]); {
+		case strength > strongest:
+			strongest = strength
+			expected = []string{metadata}
+		case strength == strongest:
+			expected = append(expected, metadata)
+		}
+	}
+	if strongest < 0 {
+		return nil
+	}
+
+	actual, _ := Hash(ALGORITHMS[strongest], content)
+	for _, metadata := range expected {
+		if subtle.ConstantTimeCompare([]byte(metadata), []byte(actual)) == 1 {
+			return nil
+		}
+	}
+	return fmt.Errorf("Content hashes to %s, expected %s", actual, strings.Join(expected, " or "))
+}
+
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,38 @@
+package sri
+
+import "testing"
+
+func TestHash(t *testing.T) {
+	// From the Subresource Integrity specification
+	content := []byte("alert('Hello, world.');")
+	expected := "sha384-H8BRh8j48O9oYatfu5AZzq6A9RINhZO5H16dQZngK7T62em8MUt1FLm52t+eX6xO"
+	if integrity, err := Hash("sha384", content); err != nil || integrity != expected {
+		t.Errorf("Expected %v, got %v (%v)", expected, integrity, err)
+	}
+
+	if _, err := Hash("md5", content); err == nil {
+		t.Error("Expected md5 to be refused")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	content := []byte("alert('Hello, world.');")
+	sha256, _ := Hash("sha256", content)
+	sha384, _ := Hash("sha384", content)
+	other, _ := Hash("sha384", []byte("alert('Goodbye.');"))
+
+	valid := []string{sha384, sha256, other + " " + sha384, sha384 + "?opt", "md5-abc", "", "sha384-wrong " + sha384}
+	for _, integrity := range valid {
+		if err := Verify(integrity, content); err != nil {
+			t.Errorf("Expected %q to match: %v", integrity, err)
+		}
+	}
+
+	// Only the strongest algorithm counts
+	invalid := []string{other, sha256 + " " + other, "sha512-wrong " + sha384}
+	for _, integrity := range invalid {
+		if err := Verify(integrity, content); err == nil {
+			t.Errorf("Expected %q not to match", integrity)
+		}
+	}
+}
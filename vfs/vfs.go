@@ -0,0 +1,184 @@
+// Package vfs provides the file system plumbing used by vulcanize: helpers for
+// reading from the local disk through an fs.FS, an in-memory overlay, and
+// sinks that receive output files.
+package vfs
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink receives the files produced by a build
+type Sink interface {
+	WriteFile(name string, data []byte) error
+}
+
+// OSSink writes files to the local disk, creating directories as needed.
+// Names are OS paths.
+type OSSink struct{}
+
+func (OSSink) WriteFile(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0775); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, data, 0775)
+}
+
+// MemSink collects files in memory, keyed by name
+type MemSink struct {
+	mu    sync.Mutex
+	Files map[string][]byte
+}
+
+// NewMemSink creates an empty MemSink
+func NewMemSink() *MemSink {
+	return &MemSink{Files: make(map[string][]byte)}
+}
+
+func (s *MemSink) WriteFile(name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Files[name] = append([]byte(nil), data...)
+	return nil
+}
+
+// OS returns a file system rooted at the root of the volume holding the
+// working directory, along with the slash-separated names of the given OS
+// paths within it. Relative paths are resolved against the working directory.
+// Rooting the file system there lets imports reach any file above the inputs.
+func OS(paths ...string) (fs.FS, []string, error) {
+	root, err := osRoot()
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, 0, len(paths))
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		rel, err := filepath.Rel(root, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, nil, fmt.Errorf("%s is not on the same volume as the working directory", p)
+		}
+		names = append(names, filepath.ToSlash(rel))
+	}
+	return os.DirFS(root), names, nil
+}
+
+// OSPath converts a name in the file system returned by OS back to an OS
+// path, relative to the working directory when possible
+func OSPath(name string) string {
+	root, err := osRoot()
+	if err != nil {
+		return name
+	}
+	abs := filepath.Join(root, filepath.FromSlash(name))
+	wd, err := os.Getwd()
+	if err != nil {
+		return abs
+	}
+	if rel, err := filepath.Rel(wd, abs); err == nil {
+		return rel
+	}
+	return abs
+}
+
+// osRoot returns the root of the volume holding the working directory
+func osRoot() (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return filepath.VolumeName(wd) + string(filepath.Separator), nil
+}
+
+// Overlay is a file system that serves Files in preference to Base. It can be
+// used to vulcanize unsaved editor buffers. Names in Files are slash-separated
+// paths, as used by fs.FS.
+type Overlay struct {
+	Base  fs.FS
+	Files map[string][]byte
+}
+
+func (o *Overlay) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if data, ok := o.Files[name]; ok {
+		return &memFile{name: path.Base(name), Reader: bytes.NewReader(data), size: int64(len(data))}, nil
+	}
+	if o.Base == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return o.Base.Open(name)
+}
+
+// memFile is an open file from an Overlay
+type memFile struct {
+	*bytes.Reader
+	name string
+	size int64
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	return memFileInfo{name: f.name, size: f.size}, nil
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+type memFileInfo struct {
+	name string
+	size int64
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) Mode() fs.FileMode  { return 0444 }
+func (i memFileInfo) ModTime() time.Time { return time.Time{} }
+func (i memFileInfo) IsDir() bool        { return false }
+func (i memFileInfo) Sys() interface{}   { return nil }
+
+// Recorder is a file system that remembers the name of every file opened
+// through it, including ones that did not exist
+type Recorder struct {
+	FS fs.FS
+
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+// NewRecorder creates a Recorder reading from fsys
+func NewRecorder(fsys fs.FS) *Recorder {
+	return &Recorder{FS: fsys, names: make(map[string]bool)}
+}
+
+func (r *Recorder) Open(name string) (fs.File, error) {
+	r.mu.Lock()
+	r.names[name] = true
+	r.mu.Unlock()
+	return r.FS.Open(name)
+}
+
+// Names returns the sorted names of the files opened so far
+func (r *Recorder) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.names))
+	for name := range r.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
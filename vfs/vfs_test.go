@@ -0,0 +1,73 @@
+package vfs
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOverlay(t *testing.T) {
+	base := fstest.MapFS{
+		"a.html": &fstest.MapFile{Data: []byte("saved")},
+		"b.html": &fstest.MapFile{Data: []byte("untouched")},
+	}
+	o := &Overlay{Base: base, Files: map[string][]byte{"a.html": []byte("unsaved")}}
+
+	content, err := fs.ReadFile(o, "a.html")
+	if err != nil || string(content) != "unsaved" {
+		t.Errorf("Expected overlay content, got %q (%v)", content, err)
+	}
+
+	content, err = fs.ReadFile(o, "b.html")
+	if err != nil || string(content) != "untouched" {
+		t.Errorf("Expected base content, got %q (%v)", content, err)
+	}
+
+	if _, err = fs.ReadFile(o, "c.html"); err == nil {
+		t.Error("Expected missing file to fail")
+	}
+}
+
+func TestMemSink(t *testing.T) {
+	s := NewMemSink()
+	s.WriteFile("out.html", []byte("<p>"))
+	if string(s.Files["out.html"]) != "<p>" {
+		t.Errorf("Expected written file, got %q", s.Files["out.html"])
+	}
+}
+
+func TestOS(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "lib"), 0775); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := os.WriteFile(filepath.Join(dir, "lib", "x.html"), []byte("lib"), 0664); err != nil {
+		t.Fatal(err.Error())
+	}
+	input, output := filepath.Join(dir, "app", "index.html"), filepath.Join(dir, "build")
+	fsys, names, err := OS(input, output)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if path.Dir(names[0]) != path.Join(path.Dir(names[1]), "app") {
+		t.Errorf("Expected names in the same file system, got %v", names)
+	}
+
+	// Imports can go above the input's directory
+	content, err := fs.ReadFile(fsys, path.Join(path.Dir(names[0]), "../lib/x.html"))
+	if err != nil || string(content) != "lib" {
+		t.Errorf("Expected lib, got %q (%v)", content, err)
+	}
+
+	for i, p := range []string{input, output} {
+		if abs, _ := filepath.Abs(OSPath(names[i])); abs != p {
+			t.Errorf("Expected %v, got %v", p, abs)
+		}
+	}
+	if _, names, _ = OS(filepath.Join("app", "index.html")); OSPath(names[0]) != filepath.Join("app", "index.html") {
+		t.Errorf("Expected a path relative to the working directory, got %v", OSPath(names[0]))
+	}
+}
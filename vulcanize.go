@@ -1,18 +1,22 @@
 package main
 
 import (
-	"fmt"
-	"io/ioutil"
-	"net/url"
+	"bytes"
+	"errors"
+	"io/fs"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"time"
 
-	"github.com/tbuckley/vulcanize/htmlutils"
-	"github.com/tbuckley/vulcanize/importer"
-	"github.com/tbuckley/vulcanize/inliner"
+	"github.com/tbuckley/vulcanize/diagnostics"
 	"github.com/tbuckley/vulcanize/optparser"
+	"github.com/tbuckley/vulcanize/server"
+	"github.com/tbuckley/vulcanize/vfs"
+	"github.com/tbuckley/vulcanize/vulcanize"
+	"github.com/tbuckley/vulcanize/watch"
 )
 
 func main() {
@@ -22,154 +26,184 @@ func main() {
 	options, err := optparser.Parse()
 	handleError(err)
 
-	// Import doc
-	importer := importer.New(options.Excludes.Imports, options.Excludes.Styles, options.OutputDir)
-	doc, err := importer.Flatten(options.Input, nil)
+	if options.Serve {
+		handler := server.New(os.DirFS(options.Dir), options.Options)
+		options.Logger.Info("Serving", "dir", options.Dir, "addr", options.Addr)
+		handleError(http.ListenAndServe(options.Addr, handler))
+		return
+	}
+
+	// Read everything through the local disk, using paths within it
+	fsys, opts, inputs, err := fsOptions(options)
 	handleError(err)
 
-	// Messy logic for inlining and handling csp
-	if options.Inline {
-		err := inliner.InlineScripts(doc, options.OutputDir, options.Excludes.Scripts)
-		handleError(err)
-	}
-	UseNamedPolymerInvocations(doc, options.Verbose)
-	if options.CSP {
-		SeparateScripts(doc, options.CSPFile, options.Verbose)
+	if !options.Watch {
+		opts.FS = fsys
+		if !report(options, build(options, opts, inputs)) {
+			os.Exit(1)
+		}
+		return
 	}
 
-	// Clean up
-	DeduplicateImports(doc)
-	if options.Strip {
-		RemoveCommentsAndWhitespace(doc)
-	}
+	watcher := watch.New(fsys)
+	for {
+		recorder := vfs.NewRecorder(fsys)
+		opts.FS = recorder
+		start := time.Now()
+		misses := opts.Cache.Misses
+		if report(options, build(options, opts, inputs)) {
+			options.Logger.Info("Built", "inputs", strings.Join(options.Inputs, ","), "duration", time.Since(start), "parsed", opts.Cache.Misses-misses)
+		}
 
-	WriteFile(doc, options.Output)
+		watcher.Watch(recorder.Names())
+		options.Logger.Info("Watching", "files", watcher.Len())
+		changed := watcher.Wait(nil)
+		for i, name := range changed {
+			changed[i] = path.Base(name)
+		}
+		options.Logger.Info("Changed", "files", strings.Join(changed, ","))
+	}
 }
 
-func handleError(err error) {
+// fsOptions returns the file system from vfs.OS holding the inputs, along
+// with a copy of options whose file names are names within it, and the names
+// of the inputs. Output files other than the scripts and styles, whose names
+// end up in the document and the manifest, keep their OS paths.
+func fsOptions(options *optparser.Options) (fs.FS, vulcanize.Options, []string, error) {
+	opts := options.Options
+	paths := append(append([]string(nil), options.Inputs...), options.OutputDir)
+	files := make([]*string, 0, 2)
+	for _, f := range []*string{&opts.CSPFile, &opts.CSSFile} {
+		if *f != "" {
+			files = append(files, f)
+			paths = append(paths, *f)
+		}
+	}
+	fsys, names, err := vfs.OS(paths...)
 	if err != nil {
-		fmt.Printf("Error: %v", err.Error())
-		os.Exit(-1)
+		return nil, opts, nil, err
+	}
+	inputs := names[:len(options.Inputs)]
+	opts.Input, opts.OutputDir = inputs[0], names[len(inputs)]
+	for i, f := range files {
+		*f = names[len(inputs)+1+i]
 	}
+	return fsys, opts, inputs, nil
 }
 
-func UseNamedPolymerInvocations(doc *htmlutils.Fragment, verbose bool) {
-	// script:not([type]):not([src]), script[type="text/javascript"]:not([src])
-	pred := htmlutils.AndP(
-		htmlutils.HasTagnameP("script"),
-		htmlutils.NotP(htmlutils.HasAttrP("src")),
-		htmlutils.OrP(
-			htmlutils.NotP(htmlutils.HasAttrP("type")),
-			htmlutils.HasAttrValueP("type", "text/javascript")))
-
-	POLYMER_INVOCATION := regexp.MustCompile("Polymer\\(([^,{]+)?(?:,\\s*)?({|\\))")
-	inlineScripts := doc.Search(pred)
-	for _, script := range inlineScripts {
-		content := htmlutils.TextContent(script)
-		parentElement := htmlutils.Closest(script, htmlutils.HasTagnameP("polymer-element"))
-		if parentElement != nil {
-			match := POLYMER_INVOCATION.FindStringSubmatch(content)
-			if len(match) != 0 && match[1] == "" {
-				name, _ := htmlutils.Attr(parentElement, "name")
-				// @TODO handle case where name is not defined
-				namedInvocation := "Polymer('" + name + "'"
-				if match[2] == "{" {
-					namedInvocation += ",{"
-				} else {
-					namedInvocation += ")"
-				}
-				content = strings.Replace(content, match[0], namedInvocation, 1)
-				if verbose {
-					fmt.Printf("%s -> %s\n", match[0], namedInvocation)
-				}
-				htmlutils.SetTextContent(script, content)
-			}
+// build vulcanizes the documents described by opts and writes the output
+// files named in options. It returns the warnings found, followed by the
+// error that stopped the build, if any.
+func build(options *optparser.Options, opts vulcanize.Options, inputs []string) []*diagnostics.Diagnostic {
+	if len(inputs) > 1 {
+		return buildEntries(options, opts, inputs)
+	}
+
+	result, err := vulcanize.Vulcanize(opts)
+	diags := osPaths(append(result.Warnings, diagnostics.All(err)...))
+	if result.HTML == "" {
+		return diags
+	}
+
+	err = vulcanize.Write(vfs.OSSink{}, options.Options, result)
+	if err == nil && options.HashNames {
+		err = result.Manifest.Write(vfs.OSSink{}, options.OutputDir)
+	}
+	if err == nil && options.Graph != "" {
+		result.Graph.Rename(osName)
+		buf := new(bytes.Buffer)
+		if err = result.Graph.Write(buf, options.Graph); err == nil {
+			err = vfs.OSSink{}.WriteFile(options.Graph, buf.Bytes())
 		}
 	}
+	if err != nil {
+		diags = append(diags, diagnostics.FromError(err))
+	}
+	return diags
 }
 
-func SeparateScripts(doc *htmlutils.Fragment, filename string, verbose bool) {
-	if verbose {
-		fmt.Println("Separating scripts into separate file")
-	}
-
-	// script:not([type]):not([src]), script[type="text/javascript"]:not([src])
-	pred := htmlutils.AndP(
-		htmlutils.HasTagnameP("script"),
-		htmlutils.NotP(htmlutils.HasAttrP("src")),
-		htmlutils.OrP(
-			htmlutils.NotP(htmlutils.HasAttrP("type")),
-			htmlutils.HasAttrValueP("type", "text/javascript")))
-
-	inlineScripts := doc.Search(pred)
-	scripts := make([]string, 0, len(inlineScripts))
-	for _, script := range inlineScripts {
-		content := htmlutils.TextContent(script)
-		scripts = append(scripts, content)
-		htmlutils.RemoveNode(doc, script)
-	}
-
-	scriptContent := strings.Join(scripts, ";\n")
-	// @TODO compress if --strip is set
-	ioutil.WriteFile(filename, []byte(scriptContent), 0775)
-
-	// insert out-of-lined script into document
-	basename := filepath.Base(filename)
-	script := htmlutils.CreateExternalScript(basename)
-	matches := doc.Search(htmlutils.HasTagnameP("body"))
-	// TODO ensure that len(matches) > 0
-	body := matches[0]
-	body.AppendChild(script)
+// buildEntries vulcanizes several pages into the output directory
+func buildEntries(options *optparser.Options, opts vulcanize.Options, inputs []string) []*diagnostics.Diagnostic {
+	entries, err := vulcanize.VulcanizeEntries(opts, inputs, options.Shared)
+	if entries == nil {
+		return osPaths(diagnostics.All(err))
+	}
+	diags := make([]*diagnostics.Diagnostic, 0)
+	manifest := make(vulcanize.Manifest)
+	for _, entry := range entries {
+		diags = append(diags, osPaths(entry.Result.Warnings)...)
+		manifest.Add(entry.Result.Manifest)
+		entryOptions := options.Options
+		entryOptions.Output = filepath.Join(options.OutputDir, entry.Name)
+		entryOptions.CSPFile = filepath.Join(options.OutputDir, entry.CSPName)
+		entryOptions.CSSFile = filepath.Join(options.OutputDir, entry.CSSName)
+		entryOptions.CSPPolicyFile = filepath.Join(options.OutputDir, entry.PolicyName)
+		if err := vulcanize.Write(vfs.OSSink{}, entryOptions, entry.Result); err != nil {
+			return append(diags, diagnostics.FromError(err))
+		}
+	}
+	if options.HashNames {
+		if err := manifest.Write(vfs.OSSink{}, options.OutputDir); err != nil {
+			return append(diags, diagnostics.FromError(err))
+		}
+	}
+	return append(diags, osPaths(diagnostics.All(err))...)
 }
 
-func DeduplicateImports(doc *htmlutils.Fragment) {
-	read := make(map[string]bool)
-
-	fn := func(n *html.Node) bool {
-		val, _ := htmlutils.Attr(n, "href")
-
-		// parse the href attribute as a URL path, default to http scheme
-		u := &url.URL{
-			Scheme: "http",
-		}
-		u, err := u.Parse(val)
-		// assume broken urls are not duplicates
-		if err != nil {
-			return false
+// osPaths returns copies of diags naming files, in their location and in the
+// message of read errors, by their OS paths rather than their names in the
+// file system from vfs.OS
+func osPaths(diags []*diagnostics.Diagnostic) []*diagnostics.Diagnostic {
+	mapped := make([]*diagnostics.Diagnostic, 0, len(diags))
+	for _, d := range diags {
+		c := *d
+		c.File = osName(c.File)
+		var pathErr *fs.PathError
+		if errors.As(d.Err, &pathErr) {
+			c.Message = strings.Replace(c.Message, pathErr.Path, osName(pathErr.Path), 1)
 		}
-		// put the string value of the URL into the map
-		us := u.String()
-		_, ok := read[us]
-		if !ok {
-			read[us] = true
+		if d.Chain != nil {
+			c.Chain = make([]string, 0, len(d.Chain))
+			for _, name := range d.Chain {
+				c.Chain = append(c.Chain, osName(name))
+			}
 		}
-		// if that url was in the map, return true
-		return ok
+		mapped = append(mapped, &c)
 	}
+	return mapped
+}
 
-	preds := htmlutils.AndP(
-		htmlutils.HasTagnameP("link"),
-		htmlutils.HasAttrValueP("rel", "import"),
-		fn)
-
-	extras := doc.Search(preds)
-	for _, extra := range extras {
-		htmlutils.RemoveNode(doc, extra)
+// report prints diags to stderr in the format chosen by options, returning
+// false if any of them is an error. Warnings are left out in quiet mode.
+func report(options *optparser.Options, diags []*diagnostics.Diagnostic) bool {
+	ok := true
+	printed := make([]*diagnostics.Diagnostic, 0, len(diags))
+	for _, d := range diags {
+		if d.Severity == diagnostics.SEVERITY_ERROR {
+			ok = false
+		} else if options.Quiet {
+			continue
+		}
+		printed = append(printed, d)
+	}
+	if len(printed) > 0 || options.Diagnostics == "json" {
+		diagnostics.Write(os.Stderr, options.Diagnostics, printed)
 	}
+	return ok
 }
 
-func RemoveCommentsAndWhitespace(doc *htmlutils.Fragment) {
-	isCommentNode := func(n *html.Node) bool {
-		return n.Type == html.CommentNode
-	}
-	comments := doc.Search(isCommentNode)
-	for _, comment := range comments {
-		htmlutils.RemoveNode(doc, comment)
+// osName converts a name in the file system from vfs.OS to an OS path,
+// leaving remote URLs and empty names as they are
+func osName(name string) string {
+	if !fs.ValidPath(name) || name == "." {
+		return name
 	}
+	return vfs.OSPath(name)
 }
 
-func WriteFile(doc *htmlutils.Fragment, filename string) {
-	content := doc.String()
-	content = "<!doctype html>" + content
-	ioutil.WriteFile(filename, []byte(content), 0775)
+func handleError(err error) {
+	if err != nil {
+		diagnostics.WriteText(os.Stderr, []*diagnostics.Diagnostic{diagnostics.FromError(err)})
+		os.Exit(1)
+	}
 }
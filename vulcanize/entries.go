@@ -0,0 +1,188 @@
+package vulcanize
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/tbuckley/vulcanize/diagnostics"
+	"github.com/tbuckley/vulcanize/graph"
+	"github.com/tbuckley/vulcanize/htmlutils"
+	"github.com/tbuckley/vulcanize/pathresolver"
+	"github.com/tbuckley/vulcanize/vfs"
+)
+
+var (
+	// DEFAULT_SHARED names the bundle of shared imports when no name is given
+	DEFAULT_SHARED = "shared.html"
+	// POLICY_EXT replaces the extension of a page to name its
+	// Content-Security-Policy file
+	POLICY_EXT = ".csp"
+)
+
+// Entry is one output file of a multi-page build
+type Entry struct {
+	// Name is the file name, relative to OutputDir
+	Name string
+	// CSPName and CSSName are the names of the extracted script and styles,
+	// relative to OutputDir
+	CSPName string
+	CSSName string
+	// PolicyName is the name of the Content-Security-Policy file, relative
+	// to OutputDir
+	PolicyName string
+	Result     Result
+}
+
+// VulcanizeEntries vulcanizes several pages into options.OutputDir. Imports
+// used by two or more pages are flattened into a bundle named shared, which
+// each page imports in their place. The bundle, if any, is the first entry
+// returned, followed by one entry per input named after it, or after its
+// content with HashNames. Since every entry is written to the output
+// directory, inputs must not share a name, nor be named after the bundle.
+// With KeepGoing, the entries are returned along with the errors of every
+// page.
+func VulcanizeEntries(options Options, inputs []string, shared string) ([]Entry, error) {
+	if options.FS == nil {
+		return nil, fmt.Errorf("VulcanizeEntries needs an FS")
+	}
+	if shared == "" {
+		shared = DEFAULT_SHARED
+	}
+	if err := checkEntryNames(inputs, shared); err != nil {
+		return nil, err
+	}
+
+	common, err := sharedImports(options, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs diagnostics.List
+	entries := make([]Entry, 0, len(inputs)+1)
+	href := ""
+	if len(common) > 0 {
+		// The bundle is vulcanized from a document importing each shared
+		// file, placed in the output directory
+		bundle := path.Join(options.OutputDir, shared)
+		links := make([]string, 0, len(common))
+		for _, name := range common {
+			links = append(links, htmlutils.StartTag(htmlutils.CreateImport(relativeTo(options.OutputDir, name))))
+		}
+		source := "<!doctype html><html><head>\n" + strings.Join(links, "\n") + "\n</head><body></body></html>"
+
+		bundleOptions := options
+		bundleOptions.FS = &vfs.Overlay{Base: options.FS, Files: map[string][]byte{bundle: []byte(source)}}
+		entry, err := vulcanizeEntry(bundleOptions, bundle, nil, "")
+		if err != nil && entry.Result.HTML == "" {
+			return nil, err
+		}
+		errs = append(errs, diagnostics.All(err)...)
+		href = shared
+		if options.HashNames {
+			href = HashedName(shared, []byte(entry.Result.HTML))
+			entry.Result.Manifest[shared] = href
+			entry.Name = path.Base(href)
+		}
+		entries = append(entries, entry)
+	}
+
+	for _, input := range inputs {
+		entry, err := vulcanizeEntry(options, input, common, href)
+		if err != nil && entry.Result.HTML == "" {
+			return nil, err
+		}
+		errs = append(errs, diagnostics.All(err)...)
+		entries = append(entries, entry)
+	}
+	return entries, errs.Err()
+}
+
+// vulcanizeEntry vulcanizes input to a file of the same name in the output
+// directory
+func vulcanizeEntry(options Options, input string, shared []string, href string) (Entry, error) {
+	entry := Entry{Name: path.Base(input)}
+	base := entryBase(entry.Name)
+	entry.CSPName = base + ".js"
+	entry.CSSName = base + ".css"
+	entry.PolicyName = base + POLICY_EXT
+	options.Input = input
+	options.CSPFile = path.Join(options.OutputDir, entry.CSPName)
+	options.CSSFile = path.Join(options.OutputDir, entry.CSSName)
+
+	result, err := vulcanize(options, shared, href)
+	entry.Result = result
+	if result.CSPFile != "" {
+		entry.CSPName = path.Base(result.CSPFile)
+	}
+	if result.CSSFile != "" {
+		entry.CSSName = path.Base(result.CSSFile)
+	}
+	return entry, err
+}
+
+// checkEntryNames returns an error if two of inputs, or an input and the
+// bundle, would be written to the same files in the output directory
+func checkEntryNames(inputs []string, shared string) error {
+	names := map[string]string{entryBase(shared): "the shared bundle"}
+	for _, input := range inputs {
+		base := entryBase(path.Base(input))
+		if other, ok := names[base]; ok {
+			return fmt.Errorf("Cannot write both %s and %s to the output directory as %s", other, input, path.Base(input))
+		}
+		names[base] = input
+	}
+	return nil
+}
+
+// entryBase returns the name of an entry without its extension, from which
+// the names of its extracted files are derived
+func entryBase(name string) string {
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// sharedImports returns the imports reachable from two or more of inputs, in
+// the order they are first imported
+func sharedImports(options Options, inputs []string) ([]string, error) {
+	counts := make(map[string]int)
+	order := make([]string, 0)
+	for _, input := range inputs {
+		imp := newImporter(options)
+		// Errors carried past in keep-going mode are reported when the
+		// page itself is vulcanized
+		if doc, err := imp.Flatten(input, nil); doc == nil {
+			return nil, err
+		}
+		for _, n := range imp.Graph.Nodes {
+			if n.Kind != graph.KIND_IMPORT || n.Excluded || n.ID == input {
+				continue
+			}
+			if counts[n.ID] == 0 {
+				order = append(order, n.ID)
+			}
+			counts[n.ID]++
+		}
+	}
+
+	shared := make([]string, 0)
+	for _, name := range order {
+		if counts[name] > 1 {
+			shared = append(shared, name)
+		}
+	}
+	return shared, nil
+}
+
+// relativeTo returns the href of name from a document in dir
+func relativeTo(dir string, name string) string {
+	return pathresolver.RewriteRelPath(".", dir, name)
+}
+
+// importBundlePass creates a pass that imports the shared bundle at href
+// before anything else in the document
+func importBundlePass(href string) Pass {
+	return NewPass(PASS_IMPORT_BUNDLE, func(doc *htmlutils.Fragment, ctx *Context) error {
+		PrependToHead(doc, htmlutils.CreateImport(href))
+		return nil
+	})
+}
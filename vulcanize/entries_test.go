@@ -0,0 +1,94 @@
+package vulcanize
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestVulcanizeEntries(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app/home.html": &fstest.MapFile{Data: []byte(`<!doctype html><html><head>
+<link rel="import" href="elements/toolbar.html">
+<link rel="import" href="elements/home-page.html">
+</head><body></body></html>`)},
+		"app/settings.html": &fstest.MapFile{Data: []byte(`<!doctype html><html><head>
+<link rel="import" href="elements/toolbar.html">
+<link rel="import" href="elements/settings-page.html">
+</head><body></body></html>`)},
+		"app/elements/toolbar.html":       &fstest.MapFile{Data: []byte(`<link rel="import" href="button.html"><polymer-element name="x-toolbar"></polymer-element>`)},
+		"app/elements/button.html":        &fstest.MapFile{Data: []byte(`<polymer-element name="x-button"></polymer-element>`)},
+		"app/elements/home-page.html":     &fstest.MapFile{Data: []byte(`<polymer-element name="x-home"></polymer-element>`)},
+		"app/elements/settings-page.html": &fstest.MapFile{Data: []byte(`<link rel="import" href="button.html"><polymer-element name="x-settings"></polymer-element>`)},
+	}
+
+	entries, err := VulcanizeEntries(Options{FS: fsys, OutputDir: "app/build"}, []string{"app/home.html", "app/settings.html"}, "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected a bundle and 2 pages, got %v entries", len(entries))
+	}
+
+	expected := map[string][]string{
+		"shared.html":   {`name="x-button" assetpath="../elements/"`, `name="x-toolbar"`},
+		"home.html":     {`<link rel="import" href="shared.html"/>`, `name="x-home"`},
+		"settings.html": {`<link rel="import" href="shared.html"/>`, `name="x-settings"`},
+	}
+	unexpected := map[string][]string{
+		"shared.html":   {"x-home", "x-settings"},
+		"home.html":     {"x-toolbar", "x-button", "toolbar.html"},
+		"settings.html": {"x-toolbar", "x-button", "toolbar.html", "button.html"},
+	}
+	for _, entry := range entries {
+		for _, s := range expected[entry.Name] {
+			if !strings.Contains(entry.Result.HTML, s) {
+				t.Errorf("Expected %v in %v: %v", s, entry.Name, entry.Result.HTML)
+			}
+		}
+		for _, s := range unexpected[entry.Name] {
+			if strings.Contains(entry.Result.HTML, s) {
+				t.Errorf("Unexpected %v in %v: %v", s, entry.Name, entry.Result.HTML)
+			}
+		}
+	}
+
+	// The bundle can be named after its content
+	hashed, err := VulcanizeEntries(Options{FS: fsys, OutputDir: "app/build", HashNames: true}, []string{"app/home.html", "app/settings.html"}, "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	bundle := HashedName("shared.html", []byte(hashed[0].Result.HTML))
+	if hashed[0].Name != bundle || hashed[0].Result.Manifest["shared.html"] != bundle {
+		t.Errorf("Expected the bundle to be named %v, got %v", bundle, hashed[0].Name)
+	}
+	if !strings.Contains(hashed[1].Result.HTML, `<link rel="import" href="`+bundle+`"/>`) {
+		t.Errorf("Expected home.html to import %v, got %v", bundle, hashed[1].Result.HTML)
+	}
+
+	// Shared elements keep their import order
+	shared := entries[0].Result.HTML
+	if strings.Index(shared, "x-button") > strings.Index(shared, "x-toolbar") {
+		t.Errorf("x-button should precede x-toolbar in %v", shared)
+	}
+}
+
+func TestVulcanizeEntries_names(t *testing.T) {
+	fsys := fstest.MapFS{
+		"admin/index.html": &fstest.MapFile{Data: []byte(`<p>admin</p>`)},
+		"shop/index.html":  &fstest.MapFile{Data: []byte(`<p>shop</p>`)},
+		"shop/shared.html": &fstest.MapFile{Data: []byte(`<p>shared</p>`)},
+	}
+
+	_, err := VulcanizeEntries(Options{FS: fsys, OutputDir: "build"}, []string{"admin/index.html", "shop/index.html"}, "")
+	if err == nil || !strings.Contains(err.Error(), "admin/index.html and shop/index.html") {
+		t.Errorf("Expected pages with the same name to be rejected, got %v", err)
+	}
+	_, err = VulcanizeEntries(Options{FS: fsys, OutputDir: "build"}, []string{"admin/index.html", "shop/shared.html"}, "")
+	if err == nil || !strings.Contains(err.Error(), "the shared bundle and shop/shared.html") {
+		t.Errorf("Expected a page named after the bundle to be rejected, got %v", err)
+	}
+	if _, err := VulcanizeEntries(Options{FS: fsys, OutputDir: "build"}, []string{"admin/index.html", "shop/shared.html"}, "common.html"); err != nil {
+		t.Errorf("Expected distinct names to be accepted, got %v", err)
+	}
+}
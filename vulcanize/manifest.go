@@ -0,0 +1,107 @@
+package vulcanize
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path"
+	"strings"
+
+	"github.com/tbuckley/vulcanize/htmlutils"
+	"github.com/tbuckley/vulcanize/sourcemap"
+	"github.com/tbuckley/vulcanize/vfs"
+)
+
+var (
+	// MANIFEST_FILE is written to the output directory when names are hashed
+	MANIFEST_FILE = "manifest.json"
+	// HASH_LENGTH is the number of hex digits of the hash put in file names
+	HASH_LENGTH = 8
+)
+
+// SOURCE_MAPPING_URL starts the comment linking a script to its source map
+const SOURCE_MAPPING_URL = "//# sourceMappingURL="
+
+// Manifest maps the names of emitted files to the hashed names they were
+// written to, both relative to the output directory
+type Manifest map[string]string
+
+// Add records the hashed names of other in m
+func (m Manifest) Add(other Manifest) {
+	for name, hashed := range other {
+		m[name] = hashed
+	}
+}
+
+// Write writes the manifest to MANIFEST_FILE in dir
+func (m Manifest) Write(sink vfs.Sink, dir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return sink.WriteFile(path.Join(dir, MANIFEST_FILE), append(data, '\n'))
+}
+
+// HashedName inserts a hash of content before the extension of name, as in
+// vulcanized.3f9a1c2b.js
+func HashedName(name string, content []byte) string {
+	sum := sha256.Sum256(content)
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:])[:HASH_LENGTH] + ext
+}
+
+// hashNamesPass renames the CSP scripts, their source maps and the extracted
+// styles after their content, updating the elements that load them
+func hashNamesPass(doc *htmlutils.Fragment, ctx *Context) error {
+	if !ctx.Options.HashNames {
+		return nil
+	}
+	for _, file := range ctx.Scripts {
+		if err := hashScriptName(doc, ctx, file); err != nil {
+			return err
+		}
+	}
+	if ctx.CSSFile != "" {
+		href := relativeTo(ctx.Options.OutputDir, ctx.Options.CSSFile)
+		hashed := HashedName(path.Base(ctx.Options.CSSFile), []byte(ctx.Styles))
+		ctx.CSSFile = path.Join(path.Dir(ctx.Options.CSSFile), hashed)
+		ctx.Manifest[href] = relativeTo(ctx.Options.OutputDir, ctx.CSSFile)
+		links := doc.Search(htmlutils.AndP(htmlutils.IsStylesheet, htmlutils.HasAttrValueP("href", href)))
+		for _, link := range links {
+			htmlutils.SetAttr(link, "href", ctx.Manifest[href])
+		}
+	}
+	return nil
+}
+
+// hashScriptName renames a CSP script and its source map
+func hashScriptName(doc *htmlutils.Fragment, ctx *Context, file *ScriptFile) error {
+	name := path.Base(file.Name)
+	content := file.Content
+	if i := strings.LastIndex(content, SOURCE_MAPPING_URL); i >= 0 && file.SourceMap != "" {
+		content = content[:i]
+	}
+	hashed := HashedName(name, []byte(content))
+
+	if file.SourceMap != "" {
+		file.Content = content + SOURCE_MAPPING_URL + hashed + ".map\n"
+		m := new(sourcemap.Map)
+		if err := json.Unmarshal([]byte(file.SourceMap), m); err != nil {
+			return err
+		}
+		m.File = hashed
+		file.SourceMap = m.JSON()
+	}
+	scripts := doc.Search(htmlutils.AndP(htmlutils.HasTagnameP("script"), htmlutils.HasAttrValueP("src", name)))
+	for _, script := range scripts {
+		htmlutils.SetAttr(script, "src", hashed)
+	}
+
+	logical := relativeTo(ctx.Options.OutputDir, file.Name)
+	file.Name = path.Join(path.Dir(file.Name), hashed)
+	ctx.Manifest[logical] = relativeTo(ctx.Options.OutputDir, file.Name)
+	if file.SourceMap != "" {
+		ctx.Manifest[logical+".map"] = ctx.Manifest[logical] + ".map"
+	}
+	return nil
+}
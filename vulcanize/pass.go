@@ -0,0 +1,308 @@
+package vulcanize
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/tbuckley/vulcanize/diagnostics"
+	"github.com/tbuckley/vulcanize/htmlutils"
+	"github.com/tbuckley/vulcanize/inliner"
+	"github.com/tbuckley/vulcanize/minify"
+)
+
+// Names of the built-in passes, in the order DefaultPipeline runs them
+const (
+	PASS_INLINE_SCRIPTS = "inline-scripts"
+	PASS_NAMED_POLYMER  = "named-polymer"
+	PASS_CSP            = "csp"
+	PASS_CSP_STYLES     = "csp-styles"
+	PASS_DEDUPLICATE    = "deduplicate-imports"
+	PASS_STRIP          = "strip"
+	PASS_FORMAT         = "format"
+	PASS_HASH_NAMES     = "hash-names"
+	PASS_INTEGRITY      = "integrity"
+	PASS_CSP_POLICY     = "csp-policy"
+	// PASS_IMPORT_BUNDLE runs first in each page of VulcanizeEntries
+	PASS_IMPORT_BUNDLE = "import-bundle"
+)
+
+// Pass is a single transformation applied to the flattened document
+type Pass interface {
+	// Name identifies the pass within a Pipeline and in the config file
+	Name() string
+	// Run transforms doc in place
+	Run(doc *htmlutils.Fragment, ctx *Context) error
+}
+
+// Context carries the options and accumulated output of a single run
+type Context struct {
+	Options  Options
+	Scripts  []*ScriptFile
+	Styles   string
+	Warnings []*diagnostics.Diagnostic
+	// CSSFile is where Styles is written, once its name is hashed. It is
+	// empty when no styles were extracted.
+	CSSFile string
+	// Manifest records the hashed names of emitted files
+	Manifest Manifest
+	// CSPPolicy is the Content-Security-Policy allowing the inline scripts
+	// and styles left in the document
+	CSPPolicy string
+	// Errors holds the errors passes carried on past in keep-going mode
+	Errors []*diagnostics.Diagnostic
+
+	// pass is the name of the running pass
+	pass string
+}
+
+// Warn records a problem that should not stop the build, using the name of
+// the running pass as its code
+func (c *Context) Warn(format string, args ...interface{}) {
+	c.AddWarnings(diagnostics.Warningf(c.pass, format, args...))
+}
+
+// AddWarnings records warnings built by a pass, such as those returned by the
+// transforms in this package
+func (c *Context) AddWarnings(warnings ...*diagnostics.Diagnostic) {
+	c.Warnings = append(c.Warnings, warnings...)
+}
+
+type passFunc struct {
+	name string
+	fn   func(doc *htmlutils.Fragment, ctx *Context) error
+}
+
+func (p *passFunc) Name() string {
+	return p.name
+}
+
+func (p *passFunc) Run(doc *htmlutils.Fragment, ctx *Context) error {
+	return p.fn(doc, ctx)
+}
+
+// NewPass creates a Pass from a function
+func NewPass(name string, fn func(doc *htmlutils.Fragment, ctx *Context) error) Pass {
+	return &passFunc{name: name, fn: fn}
+}
+
+// Pipeline is an ordered list of passes, addressable by name
+type Pipeline struct {
+	passes []Pass
+}
+
+// NewPipeline creates a pipeline running the given passes in order
+func NewPipeline(passes ...Pass) *Pipeline {
+	return &Pipeline{passes: passes}
+}
+
+// DefaultPipeline creates a pipeline holding the built-in passes. Each
+// built-in pass checks the options it depends on, so disabled features are
+// no-ops rather than missing from the pipeline.
+func DefaultPipeline() *Pipeline {
+	return NewPipeline(
+		NewPass(PASS_INLINE_SCRIPTS, inlineScriptsPass),
+		NewPass(PASS_NAMED_POLYMER, namedPolymerPass),
+		NewPass(PASS_CSP, cspPass),
+		NewPass(PASS_CSP_STYLES, cspStylesPass),
+		NewPass(PASS_DEDUPLICATE, deduplicatePass),
+		NewPass(PASS_STRIP, stripPass),
+		NewPass(PASS_FORMAT, formatPass),
+		NewPass(PASS_HASH_NAMES, hashNamesPass),
+		NewPass(PASS_INTEGRITY, integrityPass),
+		NewPass(PASS_CSP_POLICY, cspPolicyPass))
+}
+
+// Passes returns the passes in the order they will run
+func (p *Pipeline) Passes() []Pass {
+	passes := make([]Pass, len(p.passes))
+	copy(passes, p.passes)
+	return passes
+}
+
+// Append adds a pass to the end of the pipeline
+func (p *Pipeline) Append(pass Pass) {
+	p.passes = append(p.passes, pass)
+}
+
+// InsertBefore adds a pass immediately before the pass with the given name
+func (p *Pipeline) InsertBefore(name string, pass Pass) error {
+	i, err := p.index(name)
+	if err != nil {
+		return err
+	}
+	p.insert(i, pass)
+	return nil
+}
+
+// InsertAfter adds a pass immediately after the pass with the given name
+func (p *Pipeline) InsertAfter(name string, pass Pass) error {
+	i, err := p.index(name)
+	if err != nil {
+		return err
+	}
+	p.insert(i+1, pass)
+	return nil
+}
+
+// Disable removes the named passes from the pipeline
+func (p *Pipeline) Disable(names ...string) error {
+	for _, name := range names {
+		i, err := p.index(name)
+		if err != nil {
+			return err
+		}
+		p.passes = append(p.passes[:i], p.passes[i+1:]...)
+	}
+	return nil
+}
+
+// Run applies each pass to doc in order, stopping at the first error. Errors
+// that are not diagnostics are reported with the name of the pass as code.
+func (p *Pipeline) Run(doc *htmlutils.Fragment, ctx *Context) error {
+	for _, pass := range p.passes {
+		ctx.pass = pass.Name()
+		if err := pass.Run(doc, ctx); err != nil {
+			var d *diagnostics.Diagnostic
+			if errors.As(err, &d) {
+				return d
+			}
+			return diagnostics.Errorf(pass.Name(), "%s: %v", pass.Name(), err).Wrap(err)
+		}
+	}
+	return nil
+}
+
+// index returns the position of the named pass
+func (p *Pipeline) index(name string) (int, error) {
+	for i, pass := range p.passes {
+		if pass.Name() == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("Unknown pass %q", name)
+}
+
+// insert adds pass at position i
+func (p *Pipeline) insert(i int, pass Pass) {
+	p.passes = append(p.passes, nil)
+	copy(p.passes[i+1:], p.passes[i:])
+	p.passes[i] = pass
+}
+
+func inlineScriptsPass(doc *htmlutils.Fragment, ctx *Context) error {
+	if !ctx.Options.Inline {
+		return nil
+	}
+	in := inliner.New(ctx.Options.FS, ctx.Options.OutputDir)
+	in.Remote = ctx.Options.Remote
+	in.KeepGoing = ctx.Options.KeepGoing
+	in.ExcludedElements = ctx.Options.Excludes.Selectors
+	in.VerifyIntegrity = ctx.Options.SRI != ""
+	err := in.InlineScripts(doc, ctx.Options.Excludes.Scripts)
+	var errs diagnostics.List
+	if errors.As(err, &errs) {
+		ctx.Errors = append(ctx.Errors, errs...)
+		return nil
+	}
+	return err
+}
+
+func namedPolymerPass(doc *htmlutils.Fragment, ctx *Context) error {
+	ctx.AddWarnings(UseNamedPolymerInvocations(doc, ctx.Options.Logger)...)
+	return nil
+}
+
+func cspPass(doc *htmlutils.Fragment, ctx *Context) error {
+	if !ctx.Options.CSP {
+		return nil
+	}
+	ctx.Scripts = SeparateScripts(doc, ctx.Options.CSPFile, ctx.Options.OutputDir, ctx.Options.Excludes.Selectors, ctx.Options.Logger)
+	return nil
+}
+
+func cspStylesPass(doc *htmlutils.Fragment, ctx *Context) error {
+	if !ctx.Options.CSPStyles {
+		return nil
+	}
+	ctx.Styles = SeparateStyles(doc, ctx.Options.CSSFile, ctx.Options.OutputDir, ctx.Options.Excludes.Selectors, ctx.Options.Logger)
+	if ctx.Styles != "" {
+		ctx.CSSFile = ctx.Options.CSSFile
+	}
+	return nil
+}
+
+func deduplicatePass(doc *htmlutils.Fragment, ctx *Context) error {
+	DeduplicateImports(doc)
+	return nil
+}
+
+func stripPass(doc *htmlutils.Fragment, ctx *Context) error {
+	if !ctx.Options.Strip {
+		return nil
+	}
+	RemoveCommentsAndWhitespace(doc)
+
+	css, js := ctx.Options.CSSMinifier, ctx.Options.JSMinifier
+	if css == nil {
+		css = minify.CSS
+	}
+	builtin := js == nil
+	if builtin {
+		js = minify.JS
+	}
+	ctx.AddWarnings(MinifyInline(doc, css, js)...)
+	if ctx.Styles != "" {
+		styles, err := css.Minify(ctx.Styles)
+		if err != nil {
+			ctx.AddWarnings(diagnostics.Warningf(diagnostics.CODE_MINIFY, "Could not minify %s: %v", ctx.Options.CSSFile, err))
+		} else {
+			ctx.Styles = styles
+		}
+	}
+	for _, file := range ctx.Scripts {
+		// The built-in minifier keeps line breaks, so the source map still
+		// holds. Other minifiers may move code around, so the script is
+		// written without a source map.
+		content := file.Content
+		if i := strings.LastIndex(content, SOURCE_MAPPING_URL); i >= 0 && !builtin {
+			content = content[:i]
+		}
+		script, err := js.Minify(content)
+		if err != nil {
+			ctx.AddWarnings(diagnostics.Warningf(diagnostics.CODE_MINIFY, "Could not minify %s: %v", file.Name, err))
+			continue
+		}
+		file.Content = script
+		if !builtin {
+			file.SourceMap = ""
+		}
+	}
+	return nil
+}
+
+func formatPass(doc *htmlutils.Fragment, ctx *Context) error {
+	return Format(doc, ctx.Options.Format)
+}
+
+func integrityPass(doc *htmlutils.Fragment, ctx *Context) error {
+	if ctx.Options.SRI == "" {
+		return nil
+	}
+	read := func(ref string) ([]byte, error) {
+		for _, file := range ctx.Scripts {
+			if ref == path.Base(file.Name) {
+				return []byte(file.Content), nil
+			}
+		}
+		if ctx.CSSFile != "" && ref == relativeTo(ctx.Options.OutputDir, ctx.CSSFile) {
+			return []byte(ctx.Styles), nil
+		}
+		return fs.ReadFile(ctx.Options.FS, path.Join(ctx.Options.OutputDir, ref))
+	}
+	warnings, err := AddIntegrity(doc, ctx.Options.SRI, read)
+	ctx.AddWarnings(warnings...)
+	return err
+}
@@ -0,0 +1,89 @@
+package vulcanize
+
+import (
+	"testing"
+
+	"github.com/tbuckley/vulcanize/htmlutils"
+)
+
+func passNames(p *Pipeline) []string {
+	names := make([]string, 0)
+	for _, pass := range p.Passes() {
+		names = append(names, pass.Name())
+	}
+	return names
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func noop(doc *htmlutils.Fragment, ctx *Context) error {
+	return nil
+}
+
+func TestPipeline_Insert(t *testing.T) {
+	p := DefaultPipeline()
+	if err := p.InsertBefore(PASS_CSP, NewPass("stamp", noop)); err != nil {
+		t.Error(err.Error())
+	}
+	if err := p.InsertAfter(PASS_STRIP, NewPass("analytics", noop)); err != nil {
+		t.Error(err.Error())
+	}
+
+	expected := []string{PASS_INLINE_SCRIPTS, PASS_NAMED_POLYMER, "stamp", PASS_CSP, PASS_CSP_STYLES, PASS_DEDUPLICATE, PASS_STRIP, "analytics", PASS_FORMAT, PASS_HASH_NAMES, PASS_INTEGRITY, PASS_CSP_POLICY}
+	if names := passNames(p); !equalNames(names, expected) {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+
+	if err := p.InsertAfter("missing", NewPass("x", noop)); err == nil {
+		t.Error("inserting after an unknown pass should fail")
+	}
+}
+
+func TestPipeline_Disable(t *testing.T) {
+	p := DefaultPipeline()
+	if err := p.Disable(PASS_NAMED_POLYMER, PASS_STRIP); err != nil {
+		t.Error(err.Error())
+	}
+
+	expected := []string{PASS_INLINE_SCRIPTS, PASS_CSP, PASS_CSP_STYLES, PASS_DEDUPLICATE, PASS_FORMAT, PASS_HASH_NAMES, PASS_INTEGRITY, PASS_CSP_POLICY}
+	if names := passNames(p); !equalNames(names, expected) {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+
+	if err := p.Disable("missing"); err == nil {
+		t.Error("disabling an unknown pass should fail")
+	}
+}
+
+func TestPipeline_Run(t *testing.T) {
+	ran := make([]string, 0)
+	record := func(name string) Pass {
+		return NewPass(name, func(doc *htmlutils.Fragment, ctx *Context) error {
+			ran = append(ran, name)
+			ctx.Warn("ran %s", name)
+			return nil
+		})
+	}
+
+	p := NewPipeline(record("a"), record("b"))
+	ctx := new(Context)
+	if err := p.Run(new(htmlutils.Fragment), ctx); err != nil {
+		t.Error(err.Error())
+	}
+	if !equalNames(ran, []string{"a", "b"}) {
+		t.Errorf("Passes ran out of order: %v", ran)
+	}
+	if len(ctx.Warnings) != 2 {
+		t.Errorf("Expected 2 warnings, got %v", ctx.Warnings)
+	}
+}
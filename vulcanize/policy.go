@@ -0,0 +1,154 @@
+package vulcanize
+
+import (
+	"net/url"
+	"strings"
+
+	"code.google.com/p/go.net/html"
+	"github.com/tbuckley/vulcanize/htmlutils"
+	"github.com/tbuckley/vulcanize/sri"
+)
+
+// CSP_HASH is the algorithm used to allow inline scripts and styles by hash
+const CSP_HASH = "sha256"
+
+// JS_TYPES holds the script types browsers run as JavaScript, besides module
+var JS_TYPES = map[string]bool{
+	"application/ecmascript":   true,
+	"application/javascript":   true,
+	"application/x-ecmascript": true,
+	"application/x-javascript": true,
+	"text/ecmascript":          true,
+	"text/javascript":          true,
+	"text/javascript1.0":       true,
+	"text/javascript1.1":       true,
+	"text/javascript1.2":       true,
+	"text/javascript1.3":       true,
+	"text/javascript1.4":       true,
+	"text/javascript1.5":       true,
+	"text/jscript":             true,
+	"text/livescript":          true,
+	"text/x-ecmascript":        true,
+	"text/x-javascript":        true,
+}
+
+// HashPolicy returns a Content-Security-Policy allowing the scripts and styles
+// of doc: inline ones by the hash of their content, external ones by their
+// origin. Event handler and style attributes are not covered.
+func HashPolicy(doc *htmlutils.Fragment) string {
+	scripts := []string{"'self'"}
+	styles := []string{"'self'"}
+	for _, n := range doc.Search(isExecutedInlineScript) {
+		scripts = appendHash(scripts, n)
+	}
+	for _, n := range doc.Search(htmlutils.IsStyleBlock) {
+		styles = appendHash(styles, n)
+	}
+	for _, n := range doc.Search(SUBRESOURCE) {
+		if src, ok := htmlutils.Attr(n, "src"); ok {
+			scripts = appendOrigin(scripts, src)
+		} else if href, ok := htmlutils.Attr(n, "href"); ok {
+			styles = appendOrigin(styles, href)
+		}
+	}
+	return "script-src " + strings.Join(scripts, " ") + "; style-src " + strings.Join(styles, " ")
+}
+
+// isExecutedInlineScript returns true if n is an inline script the browser
+// runs: a classic script with no type or a JavaScript one, parameters aside,
+// or a module
+func isExecutedInlineScript(n *html.Node) bool {
+	if n.Type != html.ElementNode || n.Data != "script" {
+		return false
+	}
+	if _, ok := htmlutils.Attr(n, "src"); ok {
+		return false
+	}
+	t, ok := htmlutils.Attr(n, "type")
+	t = strings.ToLower(strings.TrimSpace(strings.SplitN(t, ";", 2)[0]))
+	return !ok || t == "" || t == "module" || JS_TYPES[t]
+}
+
+// appendHash adds the hash of the content of n to sources
+func appendHash(sources []string, n *html.Node) []string {
+	hash, _ := sri.Hash(CSP_HASH, []byte(htmlutils.TextContent(n)))
+	return appendSource(sources, "'"+hash+"'")
+}
+
+// appendOrigin adds the origin of ref to sources, if it is on another host
+func appendOrigin(sources []string, ref string) []string {
+	u, err := url.Parse(ref)
+	if err != nil || u.Host == "" {
+		return sources
+	}
+	if u.Scheme == "" {
+		return appendSource(sources, u.Host)
+	}
+	return appendSource(sources, u.Scheme+"://"+u.Host)
+}
+
+// appendSource adds source to sources unless it is already there
+func appendSource(sources []string, source string) []string {
+	for _, s := range sources {
+		if s == source {
+			return sources
+		}
+	}
+	return append(sources, source)
+}
+
+// PrependToHead inserts n before anything else in the <head> of doc, or at
+// the start of doc if it has no <head>
+func PrependToHead(doc *htmlutils.Fragment, n *html.Node) {
+	heads := doc.Search(htmlutils.HasTagnameP("head"))
+	switch {
+	case len(heads) > 0 && heads[0].FirstChild != nil:
+		heads[0].InsertBefore(n, heads[0].FirstChild)
+	case len(heads) > 0:
+		heads[0].AppendChild(n)
+	case doc.FirstNode != nil:
+		htmlutils.InsertBefore(doc, doc.FirstNode, n)
+	default:
+		doc.FirstNode, doc.LastNode = n, n
+	}
+}
+
+// AppendToBody inserts n after everything else in the <body> of doc, falling
+// back to its <head>, then to the end of doc
+func AppendToBody(doc *htmlutils.Fragment, n *html.Node) {
+	if bodies := doc.Search(htmlutils.HasTagnameP("body")); len(bodies) > 0 {
+		bodies[0].AppendChild(n)
+		return
+	}
+	if heads := doc.Search(htmlutils.HasTagnameP("head")); len(heads) > 0 {
+		heads[0].AppendChild(n)
+		return
+	}
+	last := doc.LastNode
+	switch {
+	case last == nil:
+		doc.FirstNode = n
+	case last.Parent != nil:
+		last.Parent.InsertBefore(n, last.NextSibling)
+	default:
+		n.PrevSibling, n.NextSibling = last, last.NextSibling
+		if last.NextSibling != nil {
+			last.NextSibling.PrevSibling = n
+		}
+		last.NextSibling = n
+	}
+	doc.LastNode = n
+}
+
+// cspPolicyPass records the hash policy of the document, adding it as a
+// <meta http-equiv> if asked to
+func cspPolicyPass(doc *htmlutils.Fragment, ctx *Context) error {
+	if !ctx.Options.CSPHashes {
+		return nil
+	}
+	ctx.CSPPolicy = HashPolicy(doc)
+	if ctx.Options.CSPMeta {
+		PrependToHead(doc, htmlutils.CreateMeta("Content-Security-Policy", ctx.CSPPolicy))
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+package vulcanize
+
+import (
+	"code.google.com/p/go.net/html"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/tbuckley/vulcanize/diagnostics"
+	"github.com/tbuckley/vulcanize/fetch"
+	"github.com/tbuckley/vulcanize/htmlutils"
+	"github.com/tbuckley/vulcanize/minify"
+	"github.com/tbuckley/vulcanize/pathresolver"
+	"github.com/tbuckley/vulcanize/sourcemap"
+	"github.com/tbuckley/vulcanize/sri"
+)
+
+var (
+	POLYMER_INVOCATION = regexp.MustCompile("Polymer\\(([^,{]+)?(?:,\\s*)?({|\\))")
+	INLINE_SCRIPT      = htmlutils.MustCompile(`script:not([type]):not([src]), script[type="text/javascript"]:not([src])`)
+	// BLOCKING_SCRIPT matches the scripts that run in document order
+	BLOCKING_SCRIPT = htmlutils.MustCompile(`script:not([type]):not([async]):not([defer]), script[type="text/javascript"]:not([async]):not([defer])`)
+	// SCOPED_STYLE_ROOT holds styles that apply to an element rather than the
+	// document
+	SCOPED_STYLE_ROOT = htmlutils.MustCompile("template, polymer-element")
+	// SUBRESOURCE matches the elements that can carry an integrity attribute
+	SUBRESOURCE = htmlutils.MustCompile(`script[src], link[rel~=stylesheet][href]`)
+)
+
+// UseNamedPolymerInvocations rewrites anonymous Polymer() calls inside a
+// <polymer-element> to pass the element's name. It returns a warning for each
+// invocation that could not be named.
+func UseNamedPolymerInvocations(doc *htmlutils.Fragment, logger *slog.Logger) []*diagnostics.Diagnostic {
+	warnings := make([]*diagnostics.Diagnostic, 0)
+	inlineScripts := doc.Search(INLINE_SCRIPT)
+	for _, script := range inlineScripts {
+		content := htmlutils.TextContent(script)
+		parentElement := htmlutils.Closest(script, htmlutils.HasTagnameP("polymer-element"))
+		if parentElement != nil {
+			match := POLYMER_INVOCATION.FindStringSubmatch(content)
+			if len(match) != 0 && match[1] == "" {
+				name, ok := htmlutils.Attr(parentElement, "name")
+				if !ok || name == "" {
+					warning := diagnostics.Warningf(diagnostics.CODE_UNNAMED_ELEMENT, "<polymer-element> has no name, leaving %s unchanged", match[0])
+					warnings = append(warnings, warning.At(doc.Position(parentElement)))
+					continue
+				}
+				namedInvocation := "Polymer('" + name + "'"
+				if match[2] == "{" {
+					namedInvocation += ",{"
+				} else {
+					namedInvocation += ")"
+				}
+				content = strings.Replace(content, match[0], namedInvocation, 1)
+				logger.Debug("Named Polymer invocation", "from", match[0], "to", namedInvocation)
+				htmlutils.SetTextContent(script, content)
+			}
+		}
+	}
+	return warnings
+}
+
+// ScriptFile is a script extracted from the document in CSP mode
+type ScriptFile struct {
+	// Name is where the script is written, as Options.CSPFile
+	Name string
+	// Content ends with a comment linking it to SourceMap, if any
+	Content string
+	// SourceMap maps Content back to the files its scripts came from. It is
+	// empty when a custom Options.JSMinifier was used.
+	SourceMap string
+}
+
+// SeparateScripts removes all inline scripts from the document, except those
+// matching one of excluded, replacing them with external scripts. Each run of
+// inline scripts with the same attributes goes to one file, loaded from the
+// place of the next script that runs in order, so that scripts still run in
+// their original order. The first file is filename, the next ones are numbered
+// after it. The last file is loaded at the end of <body>, or of <head> or doc
+// when there is none. It returns the files, with source maps naming sources
+// relative to outputDir.
+func SeparateScripts(doc *htmlutils.Fragment, filename string, outputDir string, excluded []htmlutils.HTMLPred, logger *slog.Logger) []*ScriptFile {
+	logger.Debug("Separating scripts into separate file", "file", filename)
+
+	extracted := htmlutils.AndP(INLINE_SCRIPT, htmlutils.NotP(htmlutils.OrP(excluded...)))
+	files := make([]*ScriptFile, 0)
+	run := make([]*html.Node, 0)
+	flush := func(before *html.Node) {
+		if len(run) == 0 {
+			return
+		}
+		name := filename
+		if len(files) > 0 {
+			ext := filepath.Ext(filename)
+			name = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(filename, ext), len(files)+1, ext)
+		}
+		basename := filepath.Base(name)
+		gen := sourcemap.NewGenerator()
+		line := 0
+		scripts := make([]string, 0, len(run))
+		for _, script := range run {
+			content := htmlutils.TextContent(script)
+			if src := doc.Source(script); src != nil {
+				mapLines(gen, line, content, src.Content, outputDir)
+			}
+			line += strings.Count(content, "\n") + 1
+			scripts = append(scripts, content)
+			htmlutils.RemoveNode(doc, script)
+		}
+
+		external := htmlutils.CreateExternalScript(basename)
+		for _, attr := range run[0].Attr {
+			if attr.Key != "src" && attr.Key != "integrity" {
+				external.Attr = append(external.Attr, attr)
+			}
+		}
+		if before != nil {
+			htmlutils.InsertBefore(doc, before, external)
+		} else {
+			AppendToBody(doc, external)
+		}
+
+		content := strings.Join(scripts, ";\n") + "\n" + SOURCE_MAPPING_URL + basename + ".map\n"
+		files = append(files, &ScriptFile{Name: name, Content: content, SourceMap: gen.Map(basename).JSON()})
+		run = make([]*html.Node, 0)
+	}
+
+	for _, script := range doc.Search(htmlutils.OrP(extracted, BLOCKING_SCRIPT)) {
+		switch {
+		case !extracted(script):
+			flush(script)
+		case len(run) > 0 && !sameAttrs(run[0], script):
+			flush(script)
+			run = append(run, script)
+		default:
+			run = append(run, script)
+		}
+	}
+	flush(nil)
+	return files
+}
+
+// sameAttrs returns true if a and b have the same attributes, in any order
+func sameAttrs(a *html.Node, b *html.Node) bool {
+	if len(a.Attr) != len(b.Attr) {
+		return false
+	}
+	for _, attr := range a.Attr {
+		if val, ok := htmlutils.Attr(b, attr.Key); !ok || val != attr.Val {
+			return false
+		}
+	}
+	return true
+}
+
+// SeparateStyles removes the <style> blocks of the main document, except those
+// matching one of excluded, replacing them with a single stylesheet link
+// pointing at filename. Styles inside a <template> or <polymer-element> are
+// scoped to an element and stay where they are. The link takes the place of
+// the first style in <head>, or goes at the end of <head>. It returns the
+// combined content of the removed styles, with URLs rewritten relative to
+// filename.
+func SeparateStyles(doc *htmlutils.Fragment, filename string, outputDir string, excluded []htmlutils.HTMLPred, logger *slog.Logger) string {
+	logger.Debug("Separating styles into separate file", "file", filename)
+
+	styles := doc.Search(htmlutils.AndP(
+		htmlutils.IsStyleBlock,
+		htmlutils.NotP(htmlutils.AncestorP(SCOPED_STYLE_ROOT)),
+		htmlutils.NotP(htmlutils.OrP(excluded...))))
+	if len(styles) == 0 {
+		return ""
+	}
+
+	link := htmlutils.CreateStylesheet(relativeTo(outputDir, filename))
+	linked := false
+	blocks := make([]string, 0, len(styles))
+	for _, style := range styles {
+		content := htmlutils.TextContent(style)
+		if media, ok := htmlutils.Attr(style, "media"); ok && media != "" && media != "all" {
+			content = "@media " + media + " {\n" + content + "\n}"
+		}
+		blocks = append(blocks, content)
+		if !linked && htmlutils.Closest(style, htmlutils.HasTagnameP("head")) != nil {
+			htmlutils.ReplaceNodeWithNode(doc, style, link)
+			linked = true
+		} else {
+			htmlutils.RemoveNode(doc, style)
+		}
+	}
+	if !linked {
+		if heads := doc.Search(htmlutils.HasTagnameP("head")); len(heads) > 0 {
+			heads[0].AppendChild(link)
+		} else {
+			PrependToHead(doc, link)
+		}
+	}
+
+	styleContent := strings.Join(blocks, "\n")
+	return pathresolver.RewriteURL(outputDir, filepath.Dir(filename), styleContent) + "\n"
+}
+
+// mapLines maps each line of content, starting at line of the generated
+// script, to the lines following start in its source file
+func mapLines(gen *sourcemap.Generator, line int, content string, start htmlutils.Position, outputDir string) {
+	source := start.File
+	if !fetch.IsRemote(source) {
+		source = relativeTo(outputDir, source)
+	}
+	for k := 0; k <= strings.Count(content, "\n"); k++ {
+		col := 0
+		if k == 0 {
+			col = start.Col - 1
+		}
+		gen.AddMapping(line+k, 0, source, start.Line-1+k, col)
+	}
+}
+
+// DeduplicateImports removes all but the first import of each URL
+func DeduplicateImports(doc *htmlutils.Fragment) {
+	read := make(map[string]bool)
+
+	fn := func(n *html.Node) bool {
+		val, _ := htmlutils.Attr(n, "href")
+
+		// parse the href attribute as a URL path, default to http scheme
+		u := &url.URL{
+			Scheme: "http",
+		}
+		u, err := u.Parse(val)
+		// assume broken urls are not duplicates
+		if err != nil {
+			return false
+		}
+		// put the string value of the URL into the map
+		us := u.String()
+		_, ok := read[us]
+		if !ok {
+			read[us] = true
+		}
+		// if that url was in the map, return true
+		return ok
+	}
+
+	preds := htmlutils.AndP(
+		htmlutils.HasTagnameP("link"),
+		htmlutils.HasAttrValueP("rel", "import"),
+		fn)
+
+	extras := doc.Search(preds)
+	for _, extra := range extras {
+		htmlutils.RemoveNode(doc, extra)
+	}
+}
+
+// RemoveCommentsAndWhitespace strips comment nodes from the document and
+// compacts the whitespace between elements
+func RemoveCommentsAndWhitespace(doc *htmlutils.Fragment) {
+	isCommentNode := func(n *html.Node) bool {
+		return n.Type == html.CommentNode
+	}
+	comments := doc.Search(isCommentNode)
+	for _, comment := range comments {
+		htmlutils.RemoveNode(doc, comment)
+	}
+	doc.Compact()
+}
+
+// MinifyInline minifies the content of <style> elements with css and of inline
+// scripts with js. Content that cannot be minified is left as is, with a
+// warning.
+func MinifyInline(doc *htmlutils.Fragment, css minify.Minifier, js minify.Minifier) []*diagnostics.Diagnostic {
+	warnings := make([]*diagnostics.Diagnostic, 0)
+	minifyNodes := func(nodes []*html.Node, minifier minify.Minifier) {
+		for _, n := range nodes {
+			content := htmlutils.TextContent(n)
+			minified, err := minifier.Minify(content)
+			if err != nil {
+				warnings = append(warnings, diagnostics.Warningf(diagnostics.CODE_MINIFY, "Could not minify <%s>: %v", n.Data, err).At(doc.Position(n)))
+				continue
+			}
+			if minified != content {
+				htmlutils.SetTextContent(n, minified)
+			}
+		}
+	}
+	minifyNodes(doc.Search(htmlutils.IsStyleBlock), css)
+	minifyNodes(doc.Search(INLINE_SCRIPT), js)
+	return warnings
+}
+
+// AddIntegrity adds integrity and crossorigin attributes to the scripts and
+// stylesheets in doc that reference local files, hashing their content from
+// read with algorithm. Elements that already have an integrity attribute are
+// left alone. Files that cannot be read are reported as warnings.
+func AddIntegrity(doc *htmlutils.Fragment, algorithm string, read func(ref string) ([]byte, error)) ([]*diagnostics.Diagnostic, error) {
+	warnings := make([]*diagnostics.Diagnostic, 0)
+	for _, n := range doc.Search(SUBRESOURCE) {
+		ref, ok := htmlutils.Attr(n, "src")
+		if !ok {
+			ref, _ = htmlutils.Attr(n, "href")
+		}
+		if _, ok := htmlutils.Attr(n, "integrity"); ok || ref == "" || pathresolver.ABS_URL.MatchString(ref) {
+			continue
+		}
+		u, err := url.Parse(ref)
+		if err != nil {
+			continue
+		}
+		content, err := read(u.Path)
+		if err != nil {
+			d := diagnostics.ReadError(u.Path, err).At(doc.Position(n))
+			d.Severity = diagnostics.SEVERITY_WARNING
+			warnings = append(warnings, d)
+			continue
+		}
+		integrity, err := sri.Hash(algorithm, content)
+		if err != nil {
+			return warnings, diagnostics.Errorf(diagnostics.CODE_CONFIG, "%v", err).Wrap(err)
+		}
+		htmlutils.SetAttr(n, "integrity", integrity)
+		if _, ok := htmlutils.Attr(n, "crossorigin"); !ok {
+			htmlutils.SetAttr(n, "crossorigin", "anonymous")
+		}
+	}
+	return warnings, nil
+}
+
+// Format reformats the document for the given output format
+func Format(doc *htmlutils.Fragment, format string) error {
+	switch format {
+	case "", FORMAT_PRESERVE:
+	case FORMAT_COMPACT:
+		doc.Compact()
+	case FORMAT_PRETTY:
+		doc.Compact()
+		doc.Indent(INDENT)
+	default:
+		return fmt.Errorf("Unknown format %q, use %s, %s or %s", format, FORMAT_PRETTY, FORMAT_COMPACT, FORMAT_PRESERVE)
+	}
+	return nil
+}
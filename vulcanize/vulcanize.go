@@ -0,0 +1,262 @@
+// Package vulcanize flattens a Polymer document and its HTML imports into a
+// single file. It is the library behind the vulcanize command.
+package vulcanize
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+
+	"github.com/tbuckley/vulcanize/diagnostics"
+	"github.com/tbuckley/vulcanize/fetch"
+	"github.com/tbuckley/vulcanize/graph"
+	"github.com/tbuckley/vulcanize/htmlutils"
+	"github.com/tbuckley/vulcanize/importer"
+	"github.com/tbuckley/vulcanize/minify"
+	"github.com/tbuckley/vulcanize/vfs"
+)
+
+// DOCTYPE is prepended to vulcanized documents whose input had no doctype
+const DOCTYPE = "<!doctype html>"
+
+// Output formats
+const (
+	// FORMAT_PRESERVE keeps the whitespace of the input
+	FORMAT_PRESERVE = "preserve"
+	// FORMAT_COMPACT drops whitespace that is not rendered
+	FORMAT_COMPACT = "compact"
+	// FORMAT_PRETTY puts each block element on its own, indented line
+	FORMAT_PRETTY = "pretty"
+)
+
+// INDENT is the indentation for each level of nesting in FORMAT_PRETTY
+var INDENT = "  "
+
+type Options struct {
+	// FS is the file system that Input and every referenced file is read
+	// from. Paths are slash-separated, as used by fs.FS. When nil, the
+	// working directory is used.
+	FS fs.FS
+
+	Input     string
+	Output    string
+	OutputDir string
+	Excludes  Excludes
+
+	CSP     bool
+	CSPFile string
+	// CSPStyles moves the <style> blocks of the main document to CSSFile
+	CSPStyles bool
+	CSSFile   string
+	Inline    bool
+	Strip     bool
+	// CSSMinifier and JSMinifier shrink the content of <style> elements and
+	// scripts in Strip mode. When nil, minify.CSS and minify.JS are used.
+	CSSMinifier minify.Minifier
+	JSMinifier  minify.Minifier
+	// CSPHashes keeps inline scripts and styles in the document, computing
+	// a Content-Security-Policy that allows them by hash. The policy is
+	// written to CSPPolicyFile, and added to <head> as a
+	// <meta http-equiv="Content-Security-Policy"> with CSPMeta.
+	CSPHashes     bool
+	CSPPolicyFile string
+	CSPMeta       bool
+	// HashNames names the CSP script, its source map and the shared bundle
+	// of a multi-page build after a hash of their content, as in
+	// vulcanized.3f9a1c2b.js, so that they can be cached indefinitely
+	HashNames bool
+	// SRI is the algorithm (sha256, sha384 or sha512) used to add integrity
+	// attributes to the local scripts and stylesheets the output references.
+	// Existing integrity attributes are then checked while inlining.
+	SRI string
+	// Format is how the output is laid out: FORMAT_PRESERVE (the default),
+	// FORMAT_COMPACT or FORMAT_PRETTY. The content of <pre>, <textarea>,
+	// <script>, <style> and <template> is never changed.
+	Format string
+
+	// Logger receives progress messages, mostly at debug level. When nil,
+	// nothing is logged.
+	Logger *slog.Logger
+
+	// AllowCycles reports import cycles as warnings instead of errors
+	AllowCycles bool
+	// KeepGoing carries on past imports, scripts and stylesheets that cannot
+	// be read, leaving their tags in place. The document is still built, and
+	// every such error is returned as a diagnostics.List.
+	KeepGoing bool
+	// Cache, when set, keeps parsed files between runs. Reuse it across calls
+	// to only re-parse the files that changed.
+	Cache *importer.Cache
+	// Remote, when set, fetches imports, scripts and stylesheets referenced
+	// by absolute http(s) URLs that are not excluded
+	Remote *fetch.Remote
+
+	// Pipeline holds the passes run over the flattened document. When nil,
+	// DefaultPipeline is used.
+	Pipeline *Pipeline
+	// DisabledPasses names passes to remove from the pipeline
+	DisabledPasses []string
+}
+
+type Excludes struct {
+	Imports []*regexp.Regexp
+	Scripts []*regexp.Regexp
+	Styles  []*regexp.Regexp
+	// Selectors match elements that are left as they are: imports are not
+	// flattened, scripts and stylesheets are not inlined and inline scripts
+	// stay in the document in CSP mode
+	Selectors []htmlutils.HTMLPred
+}
+
+// Result holds everything produced by a vulcanize run
+type Result struct {
+	// HTML is the rendered document, including the input's doctype
+	HTML string
+	// Scripts are the files extracted from inline scripts in CSP mode, in the
+	// order they are loaded
+	Scripts []*ScriptFile
+	// Script and SourceMap are the content and source map of the first of
+	// Scripts
+	Script    string
+	SourceMap string
+	// Styles is the content extracted from <style> blocks with CSPStyles
+	Styles string
+	// CSPFile and CSSFile are where Script and Styles are written:
+	// Options.CSPFile and Options.CSSFile, or their hashed names with
+	// HashNames. They are empty when nothing was extracted.
+	CSPFile string
+	CSSFile string
+	// Manifest maps the names of emitted files to their hashed names
+	Manifest Manifest
+	// CSPPolicy is the Content-Security-Policy header value allowing the
+	// inline scripts and styles of HTML, with CSPHashes
+	CSPPolicy string
+	// Warnings lists problems that did not stop the document from being built
+	Warnings []*diagnostics.Diagnostic
+	// Graph holds every import, stylesheet and script reachable from Input
+	Graph *graph.Graph
+}
+
+// Vulcanize flattens options.Input and runs the pipeline over it. Nothing is
+// written to disk. Errors are returned as a *diagnostics.Diagnostic.
+func Vulcanize(options Options) (Result, error) {
+	return vulcanize(options, nil, "")
+}
+
+// vulcanize is Vulcanize for a page whose shared imports are provided by the
+// bundle at href, if any
+func vulcanize(options Options, shared []string, href string) (Result, error) {
+	var result Result
+
+	pipeline := options.Pipeline
+	if pipeline == nil {
+		pipeline = DefaultPipeline()
+	} else {
+		pipeline = NewPipeline(pipeline.Passes()...)
+	}
+	if err := pipeline.Disable(options.DisabledPasses...); err != nil {
+		return result, diagnostics.Errorf(diagnostics.CODE_CONFIG, "%v", err).Wrap(err)
+	}
+
+	if href != "" {
+		pipeline.passes = append([]Pass{importBundlePass(href)}, pipeline.passes...)
+	}
+	if options.FS == nil {
+		options.FS = os.DirFS(".")
+	}
+	if options.Logger == nil {
+		options.Logger = slog.New(slog.DiscardHandler)
+	}
+
+	// Import doc
+	imp := newImporter(options)
+	imp.Skip(shared...)
+	result.Graph = imp.Graph
+	doc, err := imp.Flatten(options.Input, nil)
+	if doc == nil {
+		return result, err
+	}
+
+	ctx := &Context{
+		Options:  options,
+		Warnings: imp.Warnings(),
+		Errors:   diagnostics.All(err),
+		Manifest: make(Manifest),
+	}
+	err = pipeline.Run(doc, ctx)
+	result.Scripts = ctx.Scripts
+	if len(ctx.Scripts) > 0 {
+		result.Script = ctx.Scripts[0].Content
+		result.SourceMap = ctx.Scripts[0].SourceMap
+		result.CSPFile = ctx.Scripts[0].Name
+	}
+	result.Styles = ctx.Styles
+	result.CSSFile = ctx.CSSFile
+	result.Manifest = ctx.Manifest
+	result.CSPPolicy = ctx.CSPPolicy
+	result.Warnings = ctx.Warnings
+	if err != nil {
+		return result, diagnostics.FromError(err).InFile(options.Input)
+	}
+
+	doctype := doc.Doctype
+	if doctype == "" {
+		doctype = DOCTYPE
+	}
+	if options.Format == FORMAT_PRETTY {
+		doctype += "\n"
+	}
+	result.HTML = doctype + doc.String()
+	return result, diagnostics.List(ctx.Errors).Err()
+}
+
+// newImporter creates an importer configured by options
+func newImporter(options Options) *importer.Importer {
+	imp := importer.New(options.FS, options.Excludes.Imports, options.Excludes.Styles, options.OutputDir)
+	imp.AllowCycles = options.AllowCycles
+	if options.Logger != nil {
+		imp.Logger = options.Logger
+	}
+	imp.KeepGoing = options.KeepGoing
+	imp.Inliner.Remote = options.Remote
+	imp.Inliner.ExcludedElements = options.Excludes.Selectors
+	imp.Inliner.VerifyIntegrity = options.SRI != ""
+	imp.Cache = options.Cache
+	imp.Graph = graph.New()
+	imp.ExcludedScripts = options.Excludes.Scripts
+	return imp
+}
+
+// Write sends the output of a run to sink, using the output file names from
+// options. The CSP scripts and the extracted styles, if any, are written next
+// to options.CSPFile and options.CSSFile. The manifest is written separately,
+// by Manifest.Write.
+func Write(sink vfs.Sink, options Options, result Result) error {
+	for _, file := range result.Scripts {
+		cspFile := filepath.Join(filepath.Dir(options.CSPFile), path.Base(file.Name))
+		if err := sink.WriteFile(cspFile, []byte(file.Content)); err != nil {
+			return err
+		}
+		if file.SourceMap == "" {
+			continue
+		}
+		if err := sink.WriteFile(cspFile+".map", []byte(file.SourceMap)); err != nil {
+			return err
+		}
+	}
+	if result.CSSFile != "" {
+		cssFile := filepath.Join(filepath.Dir(options.CSSFile), path.Base(result.CSSFile))
+		if err := sink.WriteFile(cssFile, []byte(result.Styles)); err != nil {
+			return err
+		}
+	}
+	if options.CSPHashes && options.CSPPolicyFile != "" {
+		if err := sink.WriteFile(options.CSPPolicyFile, []byte(result.CSPPolicy+"\n")); err != nil {
+			return err
+		}
+	}
+	return sink.WriteFile(options.Output, []byte(result.HTML))
+}
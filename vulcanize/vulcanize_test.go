@@ -0,0 +1,596 @@
+package vulcanize
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/tbuckley/vulcanize/diagnostics"
+	"github.com/tbuckley/vulcanize/fetch"
+	"github.com/tbuckley/vulcanize/htmlutils"
+	"github.com/tbuckley/vulcanize/minify"
+	"github.com/tbuckley/vulcanize/sri"
+	"github.com/tbuckley/vulcanize/vfs"
+)
+
+var testFS = fstest.MapFS{
+	"app/index.html": &fstest.MapFile{Data: []byte(`<!doctype html>
+<html>
+<head>
+  <link rel="import" href="elements/foo-a.html">
+</head>
+<body>
+  <foo-a></foo-a>
+</body>
+</html>`)},
+	"app/elements/foo-a.html": &fstest.MapFile{Data: []byte(`<polymer-element name="foo-a">
+  <template>FOO</template>
+  <script>
+    Polymer({});
+  </script>
+</polymer-element>`)},
+}
+
+func TestVulcanize(t *testing.T) {
+	result, err := Vulcanize(Options{
+		FS:        testFS,
+		Input:     "app/index.html",
+		OutputDir: "app",
+		CSP:       true,
+		CSPFile:   "app/vulcanized.js",
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !strings.HasPrefix(result.HTML, DOCTYPE) {
+		t.Error("doctype missing from vulcanized document")
+	}
+	if !strings.Contains(result.HTML, `<polymer-element name="foo-a" assetpath="elements/">`) {
+		t.Errorf("import was not flattened: %v", result.HTML)
+	}
+	if !strings.Contains(result.HTML, `<script src="vulcanized.js"></script>`) {
+		t.Errorf("CSP script missing from vulcanized document: %v", result.HTML)
+	}
+	if !strings.Contains(result.Script, "Polymer('foo-a',{});") {
+		t.Errorf("Expected named invocation in CSP script, got %v", result.Script)
+	}
+}
+
+func TestVulcanize_SourceMap(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app/index.html":          testFS["app/index.html"],
+		"app/elements/foo-a.html": testFS["app/elements/foo-a.html"],
+		"app/main.html": &fstest.MapFile{Data: []byte(`<link rel="import" href="elements/foo-a.html">
+<script src="main.js"></script>`)},
+		"app/main.js": &fstest.MapFile{Data: []byte("start();\nrun();")},
+	}
+	result, err := Vulcanize(Options{
+		FS:        fsys,
+		Input:     "app/main.html",
+		OutputDir: "app",
+		CSP:       true,
+		CSPFile:   "app/vulcanized.js",
+		Inline:    true,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !strings.HasSuffix(result.Script, "//# sourceMappingURL=vulcanized.js.map\n") {
+		t.Errorf("Expected sourceMappingURL comment, got %v", result.Script)
+	}
+	expected := `{"version":3,"file":"vulcanized.js","sources":["elements/foo-a.html","main.js"],"names":[],"mappings":"AAEU;AACV;AACA;ACJA;AACA"}`
+	if result.SourceMap != expected {
+		t.Errorf("Expected %v, got %v", expected, result.SourceMap)
+	}
+}
+
+func TestVulcanize_ParentImport(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "app"), 0775); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "lib"), 0775); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app", "index.html"), []byte(`<link rel="import" href="../lib/x.html">`), 0664); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := os.WriteFile(filepath.Join(dir, "lib", "x.html"), []byte(`<p>lib</p>`), 0664); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	fsys, names, err := vfs.OS(filepath.Join(dir, "app", "index.html"), filepath.Join(dir, "app"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	result, err := Vulcanize(Options{FS: fsys, Input: names[0], OutputDir: names[1]})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !strings.Contains(result.HTML, "<p>lib</p>") {
+		t.Errorf("Expected the import above the input to be flattened, got %v", result.HTML)
+	}
+}
+
+func TestVulcanize_KeepGoing(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`<html><head>
+<link rel="import" href="missing.html">
+<link rel="import" href="a.html">
+</head><body><script src="missing.js"></script></body></html>`)},
+		"a.html": &fstest.MapFile{Data: []byte(`<link rel="stylesheet" href="missing.css"><p>a</p>`)},
+	}
+	options := Options{FS: fsys, Input: "index.html", OutputDir: ".", Inline: true}
+
+	if _, err := Vulcanize(options); len(diagnostics.All(err)) != 1 {
+		t.Errorf("Expected to stop at the first error, got %v", err)
+	}
+
+	options.KeepGoing = true
+	result, err := Vulcanize(options)
+	errs := diagnostics.All(err)
+	locations := make([]string, 0, len(errs))
+	for _, d := range errs {
+		locations = append(locations, d.Location())
+	}
+	expected := "index.html:2:1 a.html:1:1 index.html:4:14"
+	if strings.Join(locations, " ") != expected {
+		t.Errorf("Expected errors at %v, got %v", expected, err)
+	}
+	for _, tag := range []string{`href="missing.html"`, `href="missing.css"`, `src="missing.js"`, "<p>a</p>"} {
+		if !strings.Contains(result.HTML, tag) {
+			t.Errorf("Expected %v in %v", tag, result.HTML)
+		}
+	}
+}
+
+func TestVulcanize_ExcludedSelectors(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`<html><head>
+<link rel="import" href="lazy.html" data-lazy>
+<link rel="import" href="a.html">
+<link rel="stylesheet" href="print.css" media="print">
+</head><body>
+<script src="async.js" async></script>
+<script data-keep>keep();</script>
+<script>move();</script>
+</body></html>`)},
+		"a.html":    &fstest.MapFile{Data: []byte(`<p>a</p>`)},
+		"lazy.html": &fstest.MapFile{Data: []byte(`<p>lazy</p>`)},
+		"print.css": &fstest.MapFile{Data: []byte(`p {}`)},
+		"async.js":  &fstest.MapFile{Data: []byte(`async();`)},
+	}
+	options := Options{
+		FS:        fsys,
+		Input:     "index.html",
+		OutputDir: ".",
+		Inline:    true,
+		CSP:       true,
+		CSPFile:   "vulcanized.js",
+	}
+	for _, selector := range []string{"link[rel=import][data-lazy]", "script[async]", "[media=print]", "script[data-keep]"} {
+		options.Excludes.Selectors = append(options.Excludes.Selectors, htmlutils.MustCompile(selector))
+	}
+
+	result, err := Vulcanize(options)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, tag := range []string{`<link rel="import" href="lazy.html" data-lazy>`, "<p>a</p>",
+		`<link rel="stylesheet" href="print.css" media="print">`, `<script src="async.js" async></script>`,
+		`<script data-keep>keep();</script>`} {
+		if !strings.Contains(result.HTML, tag) {
+			t.Errorf("Expected %v in %v", tag, result.HTML)
+		}
+	}
+	if strings.Contains(result.HTML, "move();") || !strings.Contains(result.Script, "move();") {
+		t.Errorf("Expected move() to be moved to the CSP script, got %v", result.Script)
+	}
+	if result.Graph.Node("lazy.html") == nil || !result.Graph.Node("lazy.html").Excluded {
+		t.Error("Expected lazy.html to be excluded in the graph")
+	}
+}
+
+func TestVulcanize_Format(t *testing.T) {
+	options := Options{FS: testFS, Input: "app/index.html", OutputDir: "app", Format: FORMAT_PRETTY}
+	result, err := Vulcanize(options)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := `<!doctype html>
+<html>
+  <head>
+    <polymer-element name="foo-a" assetpath="elements/">
+      <template>FOO</template>
+      <script>
+    Polymer('foo-a',{});
+  </script>
+    </polymer-element>
+  </head>
+  <body><foo-a></foo-a></body>
+</html>`
+	if result.HTML != expected {
+		t.Errorf("Expected %v, got %v", expected, result.HTML)
+	}
+
+	options.Format = FORMAT_COMPACT
+	result, err = Vulcanize(options)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected = `<!doctype html><html><head><polymer-element name="foo-a" assetpath="elements/"><template>FOO</template><script>
+    Polymer('foo-a',{});
+  </script></polymer-element></head><body><foo-a></foo-a></body></html>`
+	if result.HTML != expected {
+		t.Errorf("Expected %v, got %v", expected, result.HTML)
+	}
+
+	options.Format = "tidy"
+	if _, err := Vulcanize(options); err == nil {
+		t.Error("Expected an unknown format to fail")
+	}
+}
+
+func TestVulcanize_Strip(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`<html><head>
+<!-- comment -->
+<style>
+  /*! license */
+  p { color : red; }
+</style>
+</head><body>
+<script>
+  // setup
+  var a = 1 ;
+</script>
+<script>go( a )</script>
+</body></html>`)},
+	}
+	options := Options{FS: fsys, Input: "index.html", OutputDir: ".", Strip: true}
+	result, err := Vulcanize(options)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := "<!doctype html><html><head><style>/*! license */ p{color:red}</style></head>" +
+		"<body><script>\n\nvar a=1;\n</script><script>go(a)</script></body></html>"
+	if result.HTML != expected {
+		t.Errorf("Expected %v, got %v", expected, result.HTML)
+	}
+
+	options.CSP = true
+	options.CSPFile = "vulcanized.js"
+	options.JSMinifier = minify.MinifierFunc(func(src string) (string, error) {
+		return strings.ToUpper(src), nil
+	})
+	result, err = Vulcanize(options)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if result.Script != "\n  // SETUP\n  VAR A = 1 ;\n;\nGO( A )\n" {
+		t.Errorf("Expected the script to be minified by JSMinifier, got %q", result.Script)
+	}
+	if result.SourceMap != "" {
+		t.Errorf("Expected no source map with a custom JSMinifier, got %v", result.SourceMap)
+	}
+}
+
+func TestVulcanize_HashNames(t *testing.T) {
+	options := Options{
+		FS:        testFS,
+		Input:     "app/index.html",
+		OutputDir: "app",
+		CSP:       true,
+		CSPFile:   "app/vulcanized.js",
+		HashNames: true,
+	}
+	result, err := Vulcanize(options)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	content := result.Script[:strings.LastIndex(result.Script, SOURCE_MAPPING_URL)]
+	name := HashedName("vulcanized.js", []byte(content))
+	if result.Script != content+SOURCE_MAPPING_URL+name+".map\n" {
+		t.Errorf("Expected the script to link to %v.map, got %v", name, result.Script)
+	}
+	if result.CSPFile != "app/"+name {
+		t.Errorf("Expected CSPFile app/%v, got %v", name, result.CSPFile)
+	}
+	if !strings.Contains(result.HTML, `<script src="`+name+`"></script>`) {
+		t.Errorf("Expected the hashed script in %v", result.HTML)
+	}
+	if !strings.Contains(result.SourceMap, `"file":"`+name+`"`) {
+		t.Errorf("Expected the source map to name %v, got %v", name, result.SourceMap)
+	}
+	expected := Manifest{"vulcanized.js": name, "vulcanized.js.map": name + ".map"}
+	if len(result.Manifest) != 2 || result.Manifest["vulcanized.js"] != name || result.Manifest["vulcanized.js.map"] != name+".map" {
+		t.Errorf("Expected manifest %v, got %v", expected, result.Manifest)
+	}
+
+	// The name only changes with the content
+	options.Format = FORMAT_PRETTY
+	if again, _ := Vulcanize(options); again.CSPFile != result.CSPFile {
+		t.Errorf("Expected %v, got %v", result.CSPFile, again.CSPFile)
+	}
+	options.Strip = true
+	if stripped, _ := Vulcanize(options); stripped.CSPFile == result.CSPFile {
+		t.Error("Expected the name of the minified script to change")
+	}
+}
+
+func TestHashedName(t *testing.T) {
+	name := HashedName("vulcanized.js", []byte("go();"))
+	if !strings.HasPrefix(name, "vulcanized.") || !strings.HasSuffix(name, ".js") || len(name) != len("vulcanized..js")+HASH_LENGTH {
+		t.Errorf("Expected vulcanized.<hash>.js, got %v", name)
+	}
+	if other := HashedName("vulcanized.js", []byte("stop();")); other == name {
+		t.Errorf("Expected different content to give different names, got %v twice", name)
+	}
+}
+
+func TestVulcanize_SRI(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`<html><head>
+<link rel="stylesheet" href="print.css" media="print">
+<link rel="stylesheet" href="https://cdn.example.com/a.css">
+</head><body>
+<script src="lib.js?v=1" crossorigin="use-credentials"></script>
+<script src="pinned.js" integrity="sha256-pinned"></script>
+<script>go();</script>
+</body></html>`)},
+		"print.css": &fstest.MapFile{Data: []byte(`p {}`)},
+		"lib.js":    &fstest.MapFile{Data: []byte(`lib();`)},
+		"pinned.js": &fstest.MapFile{Data: []byte(`pinned();`)},
+	}
+	options := Options{
+		FS:        fsys,
+		Input:     "index.html",
+		OutputDir: ".",
+		CSP:       true,
+		CSPFile:   "vulcanized.js",
+		SRI:       "sha384",
+	}
+	options.Excludes.Styles = []*regexp.Regexp{regexp.MustCompile("^print")}
+	result, err := Vulcanize(options)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	css, _ := sri.Hash("sha384", fsys["print.css"].Data)
+	lib, _ := sri.Hash("sha384", fsys["lib.js"].Data)
+	csp, _ := sri.Hash("sha384", []byte(result.Script))
+	expected := []string{
+		`<link rel="stylesheet" href="print.css" media="print" integrity="` + css + `" crossorigin="anonymous">`,
+		`<link rel="stylesheet" href="https://cdn.example.com/a.css">`,
+		`<script src="lib.js?v=1" crossorigin="use-credentials" integrity="` + lib + `">`,
+		`<script src="pinned.js" integrity="sha256-pinned">`,
+		`<script src="vulcanized.js" integrity="` + csp + `" crossorigin="anonymous">`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(result.HTML, e) {
+			t.Errorf("Expected %v in %v", e, result.HTML)
+		}
+	}
+
+	// Existing integrity attributes are checked when inlining
+	options.Inline = true
+	options.Excludes.Scripts = []*regexp.Regexp{regexp.MustCompile("^lib")}
+	if _, err := Vulcanize(options); err == nil || !strings.Contains(err.Error(), "Integrity check failed for pinned.js") {
+		t.Errorf("Expected pinned.js to fail its integrity check, got %v", err)
+	}
+	pinned, _ := sri.Hash("sha256", fsys["pinned.js"].Data)
+	fsys["index.html"].Data = []byte(strings.Replace(string(fsys["index.html"].Data), "sha256-pinned", pinned, 1))
+	if _, err := Vulcanize(options); err != nil {
+		t.Errorf("Expected pinned.js to pass its integrity check, got %v", err)
+	}
+}
+
+func TestVulcanize_CSPScriptOrder(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`<html><head>
+<script>one();</script>
+<script src="lib.js"></script>
+<script src="late.js" defer></script>
+<script>two();</script>
+<script nonce="abc" id="x">three();</script>
+</head><body><p>text</p></body></html>`)},
+	}
+	options := Options{FS: fsys, Input: "index.html", OutputDir: ".", CSP: true, CSPFile: "js/app.js"}
+	result, err := Vulcanize(options)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := `<script src="app.js"></script><script src="lib.js"></script>
+<script src="late.js" defer></script>
+
+<script src="app-2.js"></script>
+</head><body><p>text</p><script src="app-3.js" nonce="abc" id="x"></script></body>`
+	if !strings.Contains(result.HTML, expected) {
+		t.Errorf("Expected %v in %v", expected, result.HTML)
+	}
+	names := make([]string, 0)
+	for _, file := range result.Scripts {
+		names = append(names, file.Name)
+	}
+	if strings.Join(names, " ") != "js/app.js js/app-2.js js/app-3.js" {
+		t.Errorf("Expected three scripts, got %v", names)
+	}
+	if len(names) == 3 && !strings.HasPrefix(result.Scripts[1].Content, "two();\n//# sourceMappingURL=app-2.js.map") {
+		t.Errorf("Expected two() in the second script, got %v", result.Scripts[1].Content)
+	}
+	if result.Script != result.Scripts[0].Content || result.CSPFile != "js/app.js" {
+		t.Errorf("Expected Script to be the first file, got %v %v", result.CSPFile, result.Script)
+	}
+
+	// Without a <body>, the last script goes at the end of the fragment
+	fsys["frag.html"] = &fstest.MapFile{Data: []byte(`<polymer-element name="foo-c"><script>Polymer({});</script></polymer-element><p>end</p>`)}
+	options.Input = "frag.html"
+	result, err = Vulcanize(options)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !strings.Contains(result.HTML, `<p>end</p><script src="app.js"></script></body>`) {
+		t.Errorf("Expected the script at the end of the document, got %v", result.HTML)
+	}
+}
+
+func TestVulcanize_CSPHashes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`<html><head>
+<style>p { color: red; }</style>
+<link rel="stylesheet" href="https://fonts.example.com/a.css">
+</head><body>
+<script>go();</script>
+<script>go();</script>
+<script src="//cdn.example.com/lib.js"></script>
+<script type="text/template">{{x}}</script>
+<script type="application/json">{}</script>
+<script type="module">run();</script>
+<script type="text/JavaScript; charset=utf-8">later();</script>
+</body></html>`)},
+	}
+	options := Options{FS: fsys, Input: "index.html", OutputDir: ".", CSPHashes: true, CSPMeta: true}
+	result, err := Vulcanize(options)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	script, _ := sri.Hash("sha256", []byte("go();"))
+	module, _ := sri.Hash("sha256", []byte("run();"))
+	later, _ := sri.Hash("sha256", []byte("later();"))
+	style, _ := sri.Hash("sha256", []byte("p { color: red; }"))
+	expected := "script-src 'self' '" + script + "' '" + module + "' '" + later + "' cdn.example.com; style-src 'self' '" + style + "' https://fonts.example.com"
+	if result.CSPPolicy != expected {
+		t.Errorf("Expected %v, got %v", expected, result.CSPPolicy)
+	}
+	meta := `<head><meta http-equiv="Content-Security-Policy" content="` + expected + `"/>`
+	if !strings.Contains(result.HTML, meta) {
+		t.Errorf("Expected %v in %v", meta, result.HTML)
+	}
+	if !strings.Contains(result.HTML, "<script>go();</script>") {
+		t.Errorf("Expected inline scripts to be kept: %v", result.HTML)
+	}
+}
+
+func TestVulcanize_CSPStyles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app/index.html": &fstest.MapFile{Data: []byte(`<html><head>
+<title>App</title>
+<style>body { background: url(bkg.png); }</style>
+<style media="print">p { color: black; }</style>
+</head><body>
+<polymer-element name="foo-a"><template><style>:host { color: red; }</style></template></polymer-element>
+<style>p { margin: 0; }</style>
+</body></html>`)},
+	}
+	options := Options{FS: fsys, Input: "app/index.html", OutputDir: "app", CSPStyles: true, CSSFile: "app/css/app.css"}
+	result, err := Vulcanize(options)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := "body { background: url(../bkg.png); }\n@media print {\np { color: black; }\n}\np { margin: 0; }\n"
+	if result.Styles != expected {
+		t.Errorf("Expected %q, got %q", expected, result.Styles)
+	}
+	link := `<title>App</title>
+<link rel="stylesheet" href="css/app.css"/>
+`
+	if !strings.Contains(result.HTML, link) {
+		t.Errorf("Expected %v in %v", link, result.HTML)
+	}
+	if strings.Count(result.HTML, "<style") != 1 || !strings.Contains(result.HTML, "<style>:host { color: red; }</style>") {
+		t.Errorf("Expected only the element style to stay: %v", result.HTML)
+	}
+
+	// Without styles to extract, no link is added and no file is written
+	fsys["app/plain.html"] = &fstest.MapFile{Data: []byte(`<html><head></head><body><p>plain</p></body></html>`)}
+	options.Input, options.Output = "app/plain.html", "app/plain.out.html"
+	result, err = Vulcanize(options)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	sink := vfs.NewMemSink()
+	if err := Write(sink, options, result); err != nil {
+		t.Fatal(err.Error())
+	}
+	if result.CSSFile != "" || strings.Contains(result.HTML, "stylesheet") || len(sink.Files) != 1 {
+		t.Errorf("Expected only the document to be written, got %v %v", result.HTML, sink.Files)
+	}
+}
+
+func TestVulcanize_PassWarnings(t *testing.T) {
+	pipeline := DefaultPipeline()
+	pipeline.InsertAfter(PASS_NAMED_POLYMER, NewPass("check", func(doc *htmlutils.Fragment, ctx *Context) error {
+		ctx.Warn("found %d elements", len(doc.Search(htmlutils.HasTagnameP("polymer-element"))))
+		return nil
+	}))
+	result, err := Vulcanize(Options{FS: testFS, Input: "app/index.html", OutputDir: "app", Pipeline: pipeline})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Code != "check" || result.Warnings[0].Message != "found 1 elements" {
+		t.Errorf("Expected the warning of the check pass, got %v", result.Warnings)
+	}
+}
+
+func TestVulcanize_DisabledPasses(t *testing.T) {
+	result, err := Vulcanize(Options{
+		FS:             testFS,
+		Input:          "app/index.html",
+		OutputDir:      "app",
+		DisabledPasses: []string{PASS_NAMED_POLYMER},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !strings.Contains(result.HTML, "Polymer({});") {
+		t.Errorf("named-polymer pass ran despite being disabled: %v", result.HTML)
+	}
+}
+
+func TestVulcanize_Remote(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.FS(fstest.MapFS{
+		"components/foo-b.html": &fstest.MapFile{Data: []byte(`<link rel="stylesheet" href="foo-b.css">
+<polymer-element name="foo-b"><template><img src="icon.png"></template></polymer-element>`)},
+		"components/foo-b.css": &fstest.MapFile{Data: []byte(`:host { background: url(bkg.png); }`)},
+	})))
+	defer server.Close()
+
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`<!doctype html><html><head>
+<link rel="import" href="` + server.URL + `/components/foo-b.html">
+</head><body></body></html>`)},
+	}
+	remote := &fetch.Remote{Fetcher: &fetch.HTTPFetcher{Client: server.Client()}}
+
+	result, err := Vulcanize(Options{FS: fsys, Input: "index.html", OutputDir: ".", Remote: remote})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := []string{
+		`<polymer-element name="foo-b" assetpath="` + server.URL + `/components/">`,
+		`<img src="` + server.URL + `/components/icon.png">`,
+		`url(` + server.URL + `/components/bkg.png)`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(result.HTML, e) {
+			t.Errorf("Expected %v in %v", e, result.HTML)
+		}
+	}
+
+	// Hosts that are not allowed are left as links
+	remote = &fetch.Remote{Fetcher: &fetch.HTTPFetcher{Client: server.Client()}, Hosts: []string{"cdn.example.com"}}
+	result, err = Vulcanize(Options{FS: fsys, Input: "index.html", OutputDir: ".", Remote: remote})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !strings.Contains(result.HTML, `<link rel="import" href="`+server.URL+`/components/foo-b.html">`) {
+		t.Errorf("Expected import from a denied host to be kept: %v", result.HTML)
+	}
+}
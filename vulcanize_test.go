@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tbuckley/vulcanize/optparser"
+	"github.com/tbuckley/vulcanize/vulcanize"
+)
+
+func TestFSOptions_HashNames(t *testing.T) {
+	// The working directory is not the output directory
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "app"), 0775); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app", "index.html"), []byte(`<html><head><style>p {}</style></head><body><script>go();</script></body></html>`), 0664); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	options := &optparser.Options{Inputs: []string{filepath.Join(dir, "app", "index.html")}}
+	options.OutputDir = filepath.Join(dir, "out")
+	options.CSP, options.CSPFile = true, filepath.Join(dir, "out", "out.js")
+	options.CSPStyles, options.CSSFile = true, filepath.Join(dir, "out", "out.css")
+	options.HashNames = true
+
+	fsys, opts, _, err := fsOptions(options)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	opts.FS = fsys
+	result, err := vulcanize.Vulcanize(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, name := range []string{"out.js", "out.js.map", "out.css"} {
+		if _, ok := result.Manifest[name]; !ok {
+			t.Errorf("Expected %v in the manifest, got %v", name, result.Manifest)
+		}
+	}
+}
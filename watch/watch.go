@@ -0,0 +1,86 @@
+// Package watch polls the files a build read and reports when they change.
+package watch
+
+import (
+	"io/fs"
+	"time"
+)
+
+var (
+	DEFAULT_INTERVAL = 500 * time.Millisecond
+)
+
+// stamp identifies a version of a file. Missing files have a zero stamp.
+type stamp struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+// Watcher polls a set of files in a file system for changes
+type Watcher struct {
+	FS       fs.FS
+	Interval time.Duration
+
+	stamps map[string]stamp
+}
+
+// New creates a watcher for files in fsys, polling every DEFAULT_INTERVAL
+func New(fsys fs.FS) *Watcher {
+	return &Watcher{
+		FS:       fsys,
+		Interval: DEFAULT_INTERVAL,
+		stamps:   make(map[string]stamp),
+	}
+}
+
+// Watch replaces the watched files with names, recording their current state
+func (w *Watcher) Watch(names []string) {
+	w.stamps = make(map[string]stamp, len(names))
+	for _, name := range names {
+		w.stamps[name] = w.stat(name)
+	}
+}
+
+// Len returns the number of files being watched
+func (w *Watcher) Len() int {
+	return len(w.stamps)
+}
+
+// Changed returns the names of the watched files that were modified, created
+// or removed since they were last checked
+func (w *Watcher) Changed() []string {
+	changed := make([]string, 0)
+	for name, old := range w.stamps {
+		if current := w.stat(name); current != old {
+			w.stamps[name] = current
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// Wait polls until at least one watched file changes, and returns the
+// changed files. It returns nil if stop is closed first.
+func (w *Watcher) Wait(stop <-chan struct{}) []string {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			if changed := w.Changed(); len(changed) > 0 {
+				return changed
+			}
+		}
+	}
+}
+
+func (w *Watcher) stat(name string) stamp {
+	info, err := fs.Stat(w.FS, name)
+	if err != nil {
+		return stamp{}
+	}
+	return stamp{exists: true, size: info.Size(), modTime: info.ModTime()}
+}
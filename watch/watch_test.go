@@ -0,0 +1,49 @@
+package watch
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestWatcher_Changed(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.html": &fstest.MapFile{Data: []byte("a"), ModTime: time.Unix(1, 0)},
+		"b.html": &fstest.MapFile{Data: []byte("b"), ModTime: time.Unix(1, 0)},
+	}
+	w := New(fsys)
+	w.Watch([]string{"a.html", "b.html", "c.html"})
+
+	if changed := w.Changed(); len(changed) != 0 {
+		t.Errorf("Expected no changes, got %v", changed)
+	}
+
+	fsys["a.html"].ModTime = time.Unix(2, 0)
+	fsys["c.html"] = &fstest.MapFile{Data: []byte("c")}
+	changed := w.Changed()
+	if len(changed) != 2 {
+		t.Errorf("Expected a.html and c.html to change, got %v", changed)
+	}
+
+	if changed := w.Changed(); len(changed) != 0 {
+		t.Errorf("Changes should only be reported once, got %v", changed)
+	}
+}
+
+func TestWatcher_Wait(t *testing.T) {
+	fsys := fstest.MapFS{"a.html": &fstest.MapFile{Data: []byte("a")}}
+	w := New(fsys)
+	w.Interval = time.Millisecond
+	w.Watch([]string{"a.html"})
+
+	stop := make(chan struct{})
+	close(stop)
+	if changed := w.Wait(stop); changed != nil {
+		t.Errorf("Expected nil after stop, got %v", changed)
+	}
+
+	delete(fsys, "a.html")
+	if changed := w.Wait(make(chan struct{})); len(changed) != 1 || changed[0] != "a.html" {
+		t.Errorf("Expected removal of a.html, got %v", changed)
+	}
+}